@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/timoxa0/kxmenu/config"
+	"github.com/timoxa0/kxmenu/entry"
+)
+
+// conf is the configuration file loaded before every command
+var conf = config.Empty()
+
+// procCmdline is the running kernel's command line
+const procCmdline = "/proc/cmdline"
+
+// kernelFlags maps kxmenu.* kernel command line parameters to the flags
+// they set. kxmenu.dir and kxmenu.config have no flag.
+var kernelFlags = map[string]string{
+	"kxmenu.timeout": "timeout",
+	"kxmenu.default": "default",
+	"kxmenu.root":    "boot-root",
+	"kxmenu.hidden":  "hidden",
+	"kxmenu.debug":   "debug",
+}
+
+// kernelParams holds the kxmenu.* parameters of the running kernel's
+// command line, read before every command
+var kernelParams map[string]string
+
+// loadConfig reads the configuration file given by --config, or by
+// kxmenu.config= on the kernel command line, and applies its settings to
+// the flags not given. The kxmenu.* kernel parameters are applied on top,
+// overriding both. Only the default file may be missing.
+func loadConfig(cmd *cobra.Command) error {
+	kernelParams = readKernelParams(procCmdline)
+
+	path, _ := cmd.Flags().GetString("config")
+	explicit := cmd.Flags().Changed("config")
+	if kernelPath := kernelParams["kxmenu.config"]; kernelPath != "" && !explicit {
+		path, explicit = kernelPath, true
+	}
+
+	if path != "" {
+		cfg, err := config.Load(path)
+		switch {
+		case err == nil:
+			conf = cfg
+			if err := applyConfig(cmd, cfg); err != nil {
+				return err
+			}
+		case !os.IsNotExist(err) || explicit:
+			return err
+		}
+	}
+
+	applyKernelParams(cmd, kernelParams)
+	return nil
+}
+
+// applyConfig sets the flags not given to the configuration file settings
+func applyConfig(cmd *cobra.Command, cfg *config.Config) error {
+	for _, key := range cfg.Keys() {
+		flag := cmd.Flags().Lookup(key)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		value := cfg.Get(key)
+		if flag.Value.Type() == "stringSlice" {
+			value = strings.Join(cfg.List(key), ",")
+		}
+		if err := cmd.Flags().Set(key, value); err != nil {
+			return fmt.Errorf("%s: %s: %v", cfg.Path, key, err)
+		}
+	}
+	return nil
+}
+
+// applyKernelParams sets flags from kxmenu.* kernel parameters. A
+// parameter without a value, like kxmenu.hidden, turns a switch on. Bad
+// parameters are skipped with a warning, as only the previous bootloader
+// can fix them.
+func applyKernelParams(cmd *cobra.Command, params map[string]string) {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := params[key]
+		if key == "kxmenu.dir" || key == "kxmenu.config" {
+			continue
+		}
+		name, known := kernelFlags[key]
+		if !known {
+			fmt.Fprintf(os.Stderr, "Warning: unknown kernel parameter %s\n", key)
+			continue
+		}
+
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			continue // Not used by this command
+		}
+		if value == "" && flag.Value.Type() == "bool" {
+			value = "true"
+		}
+		if err := cmd.Flags().Set(name, value); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring kernel parameter %s: %v\n", key, err)
+		}
+	}
+}
+
+// readKernelParams returns the kxmenu.* parameters of a kernel command
+// line file. Parameters without a value map to "".
+func readKernelParams(path string) map[string]string {
+	params := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return params
+	}
+	for _, param := range entry.SplitCmdline(string(data)) {
+		key, value, _ := strings.Cut(param, "=")
+		if strings.HasPrefix(key, "kxmenu.") {
+			params[key] = strings.Trim(value, `"`)
+		}
+	}
+	return params
+}
+
+// setupLogging sends boot decisions to the --log-file, or to stderr with
+// --debug, and discards them otherwise
+func setupLogging(cmd *cobra.Command) {
+	logFile, _ := cmd.Flags().GetString("log-file")
+	debug, _ := cmd.Flags().GetBool("debug")
+
+	log.SetOutput(io.Discard)
+	if debug {
+		log.SetOutput(os.Stderr)
+	}
+	if logFile == "" {
+		return
+	}
+
+	file, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to open log file: %v\n", err)
+		return
+	}
+	log.SetOutput(file)
+}
+
+// entryDir returns the boot entry directory given by kxmenu.dir on the
+// kernel command line, as args[i], in the configuration file, or /boot
+func entryDir(args []string, i int) string {
+	if dir := kernelParams["kxmenu.dir"]; dir != "" {
+		return dir
+	}
+	if i < len(args) {
+		return args[i]
+	}
+	if dir := conf.Get("dir"); dir != "" {
+		return dir
+	}
+	return "/boot"
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/timoxa0/kxmenu/discover"
+	"github.com/timoxa0/kxmenu/entry"
+)
+
+// discovered holds the boot entries found on every block device
+type discovered struct {
+	entries []*entry.BootEntry
+	groups  map[*entry.BootEntry]string // Menu group of each entry
+	bootDir string                      // Writable boot partition for the state file, if any
+	mounter *discover.Mounter           // Holds the partitions the entries live on
+}
+
+// discoverEntries finds boot entries on every block device. The boot
+// partition a booted system mounts as /boot is made writable to keep the
+// state there.
+func discoverEntries(sources []entry.EntrySource) (*discovered, error) {
+	fmt.Println("Searching block devices for boot entries...")
+
+	results, mounter, err := discover.Discover(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	found := &discovered{groups: make(map[*entry.BootEntry]string), mounter: mounter}
+	for _, result := range results {
+		fmt.Printf("Found %d boot entries on %s\n", len(result.Entries), result.Partition)
+		for _, e := range result.Entries {
+			found.groups[e] = result.Partition.String()
+		}
+		found.entries = append(found.entries, result.Entries...)
+	}
+
+	if dir := discover.BootPartition(results); dir != "" {
+		if err := mounter.MakeWritable(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			found.bootDir = dir
+		}
+	}
+
+	return found, nil
+}
+
+// cleanupMounts unmounts discovered partitions, reporting failures
+func cleanupMounts(mounter *discover.Mounter) {
+	if mounter == nil {
+		return
+	}
+	if err := mounter.UnmountAll(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
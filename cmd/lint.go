@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/timoxa0/kxmenu/entry"
+)
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint [file|directory...]",
+	Short: "Check boot entry files for problems",
+	Long: `Check Boot Loader Specification entry files and report problems with
+file and line numbers. Exits with a non-zero status if any errors are found.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			args = []string{entryDir(nil, 0)}
+		}
+
+		bootRoot, _ := cmd.Flags().GetString("boot-root")
+		strict, _ := cmd.Flags().GetBool("strict")
+
+		if !lintEntries(args, bootRoot, strict) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	lintCmd.Flags().BoolP("strict", "s", false, "Treat warnings as errors")
+}
+
+// lintEntries prints diagnostics for the given paths and reports whether they passed
+func lintEntries(paths []string, bootRoot string, strict bool) bool {
+	passed := true
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			passed = false
+			continue
+		}
+
+		var diags []entry.Diagnostic
+		if info.IsDir() {
+			diags, err = entry.LintDir(path, bootRoot)
+		} else {
+			diags, err = entry.LintEntry(path, bootRoot)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking %s: %v\n", path, err)
+			passed = false
+			continue
+		}
+
+		for _, d := range diags {
+			fmt.Println(d)
+			if d.Severity == entry.SeverityError || strict {
+				passed = false
+			}
+		}
+	}
+
+	return passed
+}
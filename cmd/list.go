@@ -15,17 +15,20 @@ var listCmd = &cobra.Command{
 	Short: "List available boot entries in directory",
 	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		dir := "/boot"
-		if len(args) > 0 {
-			dir = args[0]
+		dir := entryDir(args, 0)
+
+		sources, err := selectedSources(cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
 
-		listEntries(dir)
+		listEntries(dir, sources)
 	},
 }
 
-func listEntries(dir string) {
-	entries, err := entry.FindEntries(dir)
+func listEntries(dir string, sources []entry.EntrySource) {
+	entries, err := entry.FindEntriesFrom(dir, sources)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error scanning directory: %v\n", err)
 		os.Exit(1)
@@ -39,14 +42,30 @@ func listEntries(dir string) {
 	fmt.Printf("Found %d boot entries in %s:\n\n", len(entries), dir)
 	for i, e := range entries {
 		fmt.Printf("%d. %s\n", i+1, filepath.Base(e.FilePath))
+		fmt.Printf("   Source: %s (%s)\n", e.Source, e.FilePath)
 		if e.Title != "" {
 			fmt.Printf("   Title: %s\n", e.Title)
 		}
 		if e.Version != "" {
 			fmt.Printf("   Version: %s\n", e.Version)
 		}
+		if e.IsBad() {
+			fmt.Printf("   Boot tries: none left, %d failed\n", e.TriesDone)
+		} else if e.BootCounting {
+			fmt.Printf("   Boot tries: %d left\n", e.TriesLeft)
+		}
 		if e.Linux != "" {
 			fmt.Printf("   Kernel: %s\n", e.Linux)
+		} else if e.Efi != "" {
+			fmt.Printf("   UKI: %s\n", e.Efi)
+		} else if e.AndroidBoot != "" {
+			fmt.Printf("   Boot image: %s\n", e.AndroidBoot)
+			if e.AndroidInitBoot != "" {
+				fmt.Printf("   init_boot image: %s\n", e.AndroidInitBoot)
+			}
+			if e.AndroidVendorBoot != "" {
+				fmt.Printf("   vendor_boot image: %s\n", e.AndroidVendorBoot)
+			}
 		}
 		fmt.Println()
 	}
@@ -2,13 +2,19 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/timoxa0/kxmenu/discover"
 	"github.com/timoxa0/kxmenu/entry"
 	"github.com/timoxa0/kxmenu/input"
 	"github.com/timoxa0/kxmenu/kexec"
 	"github.com/timoxa0/kxmenu/menu"
+	"github.com/timoxa0/kxmenu/state"
 )
 
 // menuCmd represents the menu command
@@ -17,41 +23,146 @@ var menuCmd = &cobra.Command{
 	Short: "Show interactive GRUB2-style boot menu with hardware key support",
 	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		dir := "/boot"
-		if len(args) > 0 {
-			dir = args[0]
-		}
+		dir := entryDir(args, 0)
 
-		bootRoot, _ := cmd.Flags().GetString("boot-root")
-		timeout, _ := cmd.Flags().GetInt("timeout")
+		opts := menuOptions{dir: dir}
+		opts.bootRoot, _ = cmd.Flags().GetString("boot-root")
 		noHardware, _ := cmd.Flags().GetBool("no-hardware")
+		opts.enableHardware = !noHardware
+		opts.discoverDevices, _ = cmd.Flags().GetBool("discover")
+		opts.defaultEntry, _ = cmd.Flags().GetString("default")
+		opts.statePath = statePath(cmd, dir)
+		if opts.discoverDevices {
+			// The state is kept on a discovered partition unless given
+			opts.statePath, _ = cmd.Flags().GetString("state")
+		}
+		opts.title, _ = cmd.Flags().GetString("title")
+		opts.footer, _ = cmd.Flags().GetString("footer")
+		opts.keymap, _ = cmd.Flags().GetString("keymap")
+
+		// -1 leaves the timeout to the configuration files
+		opts.timeout = -1
+		if cmd.Flags().Changed("timeout") {
+			opts.timeout, _ = cmd.Flags().GetInt("timeout")
+		}
+		if cmd.Flags().Changed("hidden") {
+			hidden, _ := cmd.Flags().GetBool("hidden")
+			opts.hidden = &hidden
+		}
+
+		themeName, _ := cmd.Flags().GetString("theme")
+		theme, err := menu.LookupTheme(themeName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.theme = conf.Colors(theme)
+
+		opts.sources, err = selectedSources(cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 
-		showEnhancedBootMenu(dir, bootRoot, timeout, !noHardware)
+		err = showEnhancedBootMenu(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	},
 }
 
+// savedDefault is the default entry policy selecting the entry booted last,
+// "@saved" in loader.conf
+const savedDefault = "saved"
+
 func init() {
 	menuCmd.Flags().IntP("timeout", "t", 0, "Menu timeout in seconds (0 = no timeout)")
 	menuCmd.Flags().BoolP("no-hardware", "n", false, "Disable hardware key detection")
+	menuCmd.Flags().BoolP("discover", "d", false, "Search all block devices for boot entries instead of a directory")
+	menuCmd.Flags().Bool("hidden", false, "Boot the default entry without showing the menu unless a key is pressed")
+	menuCmd.Flags().String("default", "", "Default entry ID or glob pattern, or \"saved\" for the entry booted last")
+	menuCmd.Flags().String("title", "kxboot - kexec-based bootloader", "Menu title")
+	menuCmd.Flags().String("footer", "", "Help text at the bottom of the menu (default lists the keys)")
+	menuCmd.Flags().String("theme", "default", "Color theme ("+strings.Join(menu.ThemeNames(), ", ")+")")
+	menuCmd.Flags().String("keymap", input.DefaultKeymap, "Keyboard layout of the entry editor ("+strings.Join(input.Keymaps(), ", ")+")")
 }
 
-func showEnhancedBootMenu(dir, bootRoot string, timeout int, enableHardware bool) {
-	// Find boot entries
-	entries, err := entry.FindEntries(dir)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error scanning directory: %v\n", err)
-		os.Exit(1)
+// menuOptions holds the settings of the menu command
+type menuOptions struct {
+	dir             string
+	bootRoot        string
+	sources         []entry.EntrySource
+	timeout         int    // -1 leaves the timeout to the configuration files
+	hidden          *bool  // nil leaves hiding the menu to the configuration files
+	defaultEntry    string // Overrides the configured default entry
+	statePath       string
+	enableHardware  bool
+	discoverDevices bool
+	title           string
+	footer          string
+	theme           menu.Theme
+	keymap          string
+}
+
+func showEnhancedBootMenu(opts menuOptions) error {
+	var entries []*entry.BootEntry
+	var groups map[*entry.BootEntry]string
+	var mounter *discover.Mounter
+
+	if opts.discoverDevices {
+		// Find boot entries on all block devices
+		found, err := discoverEntries(opts.sources)
+		if err != nil {
+			return fmt.Errorf("device discovery failed: %v", err)
+		}
+		mounter = found.mounter
+		defer cleanupMounts(mounter)
+
+		if len(found.entries) == 0 {
+			return fmt.Errorf("no boot entries found on any block device")
+		}
+		entries, groups = found.entries, found.groups
+
+		// Without --state the state lives on the boot partition
+		if opts.statePath == "" && found.bootDir != "" {
+			opts.statePath = filepath.Join(found.bootDir, stateFile)
+		}
+		if opts.statePath == "" {
+			fmt.Fprintf(os.Stderr, "Warning: no writable boot partition found, saved and one-shot entries need --state\n")
+		}
+	} else {
+		// Find boot entries
+		found, err := entry.FindEntriesFrom(opts.dir, opts.sources)
+		if err != nil {
+			return fmt.Errorf("scanning directory: %v", err)
+		}
+
+		if len(found) == 0 {
+			return fmt.Errorf("no boot entries found in %s", opts.dir)
+		}
+		entries = found
 	}
 
-	if len(entries) == 0 {
-		fmt.Printf("No boot entries found in %s\n", dir)
-		os.Exit(1)
+	// Expand variables, apply the command line policy and pick DTBs now,
+	// so the menu and the editor show what boots
+	for _, e := range entries {
+		kexec.Prepare(e, opts.bootRoot)
+	}
+
+	// A missing or unreadable state only loses the saved entry
+	store, err := state.Open(opts.statePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to read state: %v\n", err)
 	}
 
 	// Initialize input manager
 	var inputMgr *input.InputManager
-	if enableHardware {
+	if opts.enableHardware {
 		inputMgr = input.NewInputManager()
+		if err := inputMgr.SetKeymap(opts.keymap); err != nil {
+			return err
+		}
 
 		// Discover hardware input devices
 		err := inputMgr.DiscoverDevices()
@@ -68,33 +179,220 @@ func showEnhancedBootMenu(dir, bootRoot string, timeout int, enableHardware bool
 	}
 
 	// Create enhanced boot menu
-	bootMenu := menu.NewBootMenuWithInput(entries, "kxboot - kexec-based bootloader", inputMgr)
+	bootMenu := menu.NewBootMenuWithInput(entries, opts.title, inputMgr)
+	bootMenu.Footer = opts.footer
+	bootMenu.Theme = opts.theme
 
-	if timeout > 0 {
-		bootMenu.SetTimeout(timeout)
+	if len(bootMenu.Items) == 0 {
+		return fmt.Errorf("no boot entries for %s found", entry.HostArchitecture())
 	}
 
+	if opts.discoverDevices {
+		// Group entries by the partition they were found on
+		for i := range bootMenu.Items {
+			bootMenu.Items[i].Group = groups[bootMenu.Items[i].Entry]
+		}
+		applyDiscoveredBootConfigs(bootMenu, store)
+	} else {
+		applyBootConfigs(bootMenu, opts.dir, store)
+	}
+
+	// The flags take precedence over the configuration files
+	if opts.defaultEntry != "" {
+		selectDefault(bootMenu, opts.defaultEntry, store, "")
+	}
+	if opts.timeout >= 0 {
+		bootMenu.SetTimeout(opts.timeout)
+		bootMenu.Hidden = false
+	}
+	if opts.hidden != nil {
+		bootMenu.Hidden = *opts.hidden
+	}
+
+	// A one-shot entry overrides everything for exactly one boot
+	selectOneshot(bootMenu, store)
+
 	fmt.Println("")
 
 	selectedEntry, err := bootMenu.Show()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Menu error: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("menu error: %v", err)
 	}
 
 	if selectedEntry == nil {
-		fmt.Println("No entry selected")
-		os.Exit(1)
+		return fmt.Errorf("no entry selected")
 	}
 
 	fmt.Printf("\nLoading entry: %s\n", getEntryDisplayName(selectedEntry))
+	log.Printf("Booting %s (%s) from %s", selectedEntry.ID, getEntryDisplayName(selectedEntry), selectedEntry.Source)
 
 	// Load the selected entry using kexec
-	err = kexec.LoadEntryFromParsed(selectedEntry, bootRoot)
+	err = kexec.Load(selectedEntry, opts.bootRoot)
+	if err != nil {
+		log.Printf("Loading %s failed: %v", selectedEntry.ID, err)
+		return fmt.Errorf("loading entry: %v", err)
+	}
+	log.Printf("Loaded %s with options %q", selectedEntry.ID, selectedEntry.Options)
+
+	// Remember the entry for the saved default policy
+	store.Set(state.LastEntry, selectedEntry.ID)
+	if err := store.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	// Nothing may stay mounted once the new kernel takes over
+	cleanupMounts(mounter)
+
+	return kexec.Execute()
+}
+
+// selectOneshot boots the one-shot entry set by set-oneshot without showing
+// the menu. The entry is cleared first and only honored once the cleared
+// state is saved, so a hanging kernel never boots twice.
+func selectOneshot(bootMenu *menu.BootMenu, store *state.Store) {
+	id := store.Get(state.OneshotEntry)
+	if id == "" {
+		return
+	}
+
+	store.Set(state.OneshotEntry, "")
+	if err := store.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring one-shot entry %s: %v\n", id, err)
+		return
+	}
+
+	if !bootMenu.SelectEntry(id) {
+		fmt.Fprintf(os.Stderr, "Warning: one-shot entry %s not found\n", id)
+		return
+	}
+	log.Printf("One-shot entry %s selected", id)
+	bootMenu.Hidden = true
+}
+
+// selectDefault preselects the default entry given as an ID or glob pattern,
+// or as the saved default policy. Patterns match the entries found below
+// root, or all entries if root is empty.
+func selectDefault(bootMenu *menu.BootMenu, pattern string, store *state.Store, root string) {
+	if pattern == savedDefault || pattern == "@"+savedDefault {
+		// Nothing saved yet keeps the current default
+		id := store.Get(state.LastEntry)
+		if id != "" && !bootMenu.SelectEntry(id) {
+			fmt.Fprintf(os.Stderr, "Warning: saved entry %s not found\n", id)
+			return
+		}
+		if id != "" {
+			log.Printf("Default entry %s saved", id)
+		}
+		return
+	}
+
+	if !selectEntry(bootMenu, root, func(e *entry.BootEntry) bool { return e.MatchesID(pattern) }) {
+		fmt.Fprintf(os.Stderr, "Warning: no entry matches default %s\n", pattern)
+		return
+	}
+	log.Printf("Default entry %s matched %s", bootMenu.Items[bootMenu.SelectedIndex].Entry.ID, pattern)
+}
+
+// selectEntry preselects the first entry found below root, or anywhere if
+// root is empty, that match accepts and reports whether there is one
+func selectEntry(bootMenu *menu.BootMenu, root string, match func(*entry.BootEntry) bool) bool {
+	for i, item := range bootMenu.Items {
+		if (root == "" || item.Entry.BootRoot == root) && match(item.Entry) {
+			bootMenu.SelectedIndex = i
+			return true
+		}
+	}
+	return false
+}
+
+// bootConfigs holds the extlinux.conf and loader.conf of a directory,
+// either of which may be missing
+type bootConfigs struct {
+	extlinux *entry.ExtlinuxConfig
+	loader   *entry.LoaderConfig
+}
+
+// readBootConfigs reads the extlinux.conf and loader.conf found in dir
+func readBootConfigs(dir string) bootConfigs {
+	var configs bootConfigs
+	var err error
+
+	configs.extlinux, err = entry.FindExtlinuxConfig(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to read extlinux configuration: %v\n", err)
+	}
+	configs.loader, err = entry.FindLoaderConfig(dir)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading entry: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Warning: failed to read loader configuration: %v\n", err)
+	}
+	return configs
+}
+
+// hasDefault reports whether either file names a default entry
+func (c bootConfigs) hasDefault() bool {
+	return (c.extlinux != nil && c.extlinux.Default != "") || (c.loader != nil && c.loader.Default != "")
+}
+
+// apply applies the default entry, timeout and editor policy, with
+// loader.conf taking precedence over extlinux.conf. Default entries are
+// looked up below root, or among all entries if root is empty. A
+// loader.conf default of "@saved" selects the entry booted last.
+func (c bootConfigs) apply(bootMenu *menu.BootMenu, store *state.Store, root string) {
+	// Apply DEFAULT and TIMEOUT from extlinux.conf
+	if c.extlinux != nil {
+		label := c.extlinux.Default
+		if label != "" && !selectEntry(bootMenu, root, func(e *entry.BootEntry) bool { return e.ID == label }) {
+			fmt.Fprintf(os.Stderr, "Warning: default entry %s not found\n", label)
+		}
+		bootMenu.SetTimeout(c.extlinux.Timeout)
+	}
+
+	// Apply default, timeout and editor policy from systemd-boot's loader.conf
+	if c.loader != nil {
+		if c.loader.Default != "" {
+			selectDefault(bootMenu, c.loader.Default, store, root)
+		}
+		if c.loader.TimeoutSet {
+			bootMenu.SetTimeout(c.loader.Timeout)
+			bootMenu.Hidden = c.loader.Hidden
+		}
+		bootMenu.Editor = c.loader.Editor
+	}
+}
+
+// applyBootConfigs applies the default entry and timeout from the
+// extlinux.conf and loader.conf found in dir
+func applyBootConfigs(bootMenu *menu.BootMenu, dir string, store *state.Store) {
+	readBootConfigs(dir).apply(bootMenu, store, "")
+}
+
+// applyDiscoveredBootConfigs applies the extlinux.conf and loader.conf of
+// one discovered partition: the first whose files name a default entry,
+// or else the partition of the first entry. The default is looked up
+// among that partition's entries.
+func applyDiscoveredBootConfigs(bootMenu *menu.BootMenu, store *state.Store) {
+	var roots []string
+	var configs []bootConfigs
+	for _, item := range bootMenu.Items {
+		root := item.Entry.BootRoot
+		// Android boot partitions are entries themselves, without files
+		if root == "" || root == "/" || slices.Contains(roots, root) {
+			continue
+		}
+		roots = append(roots, root)
+		configs = append(configs, readBootConfigs(root))
+	}
+	if len(roots) == 0 {
+		return
+	}
+
+	for i, c := range configs {
+		if c.hasDefault() {
+			c.apply(bootMenu, store, roots[i])
+			return
+		}
 	}
+	configs[0].apply(bootMenu, store, roots[0])
 }
 
 func getEntryDisplayName(e *entry.BootEntry) string {
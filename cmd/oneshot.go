@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/timoxa0/kxmenu/entry"
+	"github.com/timoxa0/kxmenu/state"
+)
+
+// setOneshotCmd represents the set-oneshot command
+var setOneshotCmd = &cobra.Command{
+	Use:   "set-oneshot <entry-id> [directory]",
+	Short: "Boot an entry once on the next run of the menu",
+	Long: `Record an entry to boot on the next run of the menu without waiting for
+a selection. The entry is cleared before it is booted, so the following boot
+uses the normal default again. Use --clear to remove a pending entry.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if clearEntry, _ := cmd.Flags().GetBool("clear"); clearEntry {
+			return cobra.MaximumNArgs(1)(cmd, args)
+		}
+		return cobra.RangeArgs(1, 2)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		clearEntry, _ := cmd.Flags().GetBool("clear")
+
+		id := ""
+		dir := entryDir(args, 0)
+		if !clearEntry {
+			id = args[0]
+			dir = entryDir(args, 1)
+		}
+
+		sources, err := selectedSources(cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := setOneshot(id, dir, statePath(cmd, dir), sources); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	setOneshotCmd.Flags().Bool("clear", false, "Remove the pending one-shot entry")
+}
+
+// setOneshot records id as the one-shot entry, or clears it if id is empty
+func setOneshot(id, dir, path string, sources []entry.EntrySource) error {
+	store, err := state.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading state: %v", err)
+	}
+
+	// Entries on other devices cannot be checked, so a miss only warns
+	if id != "" && !hasEntry(dir, id, sources) {
+		fmt.Fprintf(os.Stderr, "Warning: no entry %s found in %s\n", id, dir)
+	}
+
+	store.Set(state.OneshotEntry, id)
+	if err := store.Save(); err != nil {
+		return err
+	}
+
+	if id == "" {
+		fmt.Println("One-shot entry cleared")
+	} else {
+		fmt.Printf("Next boot: %s\n", id)
+	}
+	return nil
+}
+
+// hasEntry checks if dir holds an entry with the given ID
+func hasEntry(dir, id string, sources []entry.EntrySource) bool {
+	entries, err := entry.FindEntriesFrom(dir, sources)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,13 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/timoxa0/kxmenu/config"
+	"github.com/timoxa0/kxmenu/kexec"
 )
 
 var (
@@ -16,7 +20,39 @@ var (
 var rootCmd = &cobra.Command{
 	Use:   "kxmenu",
 	Short: "Kernel execution menu utility",
+	Long: `Kernel execution menu utility
+
+Settings are taken, from highest to lowest precedence, from:
+
+  1. kxmenu.* parameters on the running kernel's command line:
+       kxmenu.timeout=SECONDS  menu timeout
+       kxmenu.default=ID       default entry ID, glob pattern or "saved"
+       kxmenu.dir=DIR          directory scanned for boot entries
+       kxmenu.root=DIR         root directory for boot files
+       kxmenu.hidden[=BOOL]    boot the default entry without showing the menu
+       kxmenu.debug[=BOOL]     print the kexec invocation and log to stderr
+       kxmenu.config=FILE      configuration file
+  2. command line flags and arguments
+  3. the configuration file, /etc/kxmenu.conf by default
+  4. loader.conf and extlinux.conf in the boot entry directory, or with
+     --discover on the partition holding the default entry`,
 	Version: Version,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := loadConfig(cmd); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		setupLogging(cmd)
+
+		kexec.Debug, _ = cmd.Flags().GetBool("debug")
+		kexec.BackendName, _ = cmd.Flags().GetString("kexec-backend")
+		if err := kexec.CheckBackend(kexec.BackendName); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		kexec.OverlayDir, _ = cmd.Flags().GetString("overlay-dir")
+		kexec.CmdlinePolicy, _ = cmd.Flags().GetString("cmdline-policy")
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// Default behavior: show help if no arguments
 		if len(args) == 0 {
@@ -48,10 +84,21 @@ func init() {
 	rootCmd.SetVersionTemplate(`{{printf "kxmenu version %s (built %s)\n" .Version "` + BuildTime + `"}}`)
 
 	// Global flags can be added here
+	rootCmd.PersistentFlags().String("config", config.DefaultPath, "Configuration file, flags take precedence over its settings")
 	rootCmd.PersistentFlags().StringP("boot-root", "r", "/mnt", "Root directory for boot files")
+	rootCmd.PersistentFlags().StringSlice("source", nil, "Only use these entry sources ("+sourceNames()+")")
+	rootCmd.PersistentFlags().StringSlice("no-source", nil, "Disable these entry sources")
+	rootCmd.PersistentFlags().String("overlay-dir", "", "Directory of devicetree overlays (.dtbo) applied to every entry")
+	rootCmd.PersistentFlags().String("cmdline-policy", kexec.CmdlinePolicy, "Kernel command line policy file applied to every entry")
+	rootCmd.PersistentFlags().String("state", "", "State file recording the entry booted last (default <directory>/kxmenu.state, or on the boot partition with --discover)")
+	rootCmd.PersistentFlags().String("kexec-backend", kexec.BackendName, "How kernels are loaded ("+strings.Join(kexec.BackendNames(), ", ")+")")
+	rootCmd.PersistentFlags().String("log-file", "", "File recording boot decisions")
+	rootCmd.PersistentFlags().Bool("debug", false, "Print the kexec invocation and log to stderr")
 
 	// Add commands
 	rootCmd.AddCommand(menuCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(scanCmd)
+	rootCmd.AddCommand(lintCmd)
+	rootCmd.AddCommand(setOneshotCmd)
 }
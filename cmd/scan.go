@@ -17,18 +17,21 @@ var scanCmd = &cobra.Command{
 	Short: "Scan directory and select entry interactively (simple text mode)",
 	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		dir := "/boot"
-		if len(args) > 0 {
-			dir = args[0]
-		}
+		dir := entryDir(args, 0)
 
 		bootRoot, _ := cmd.Flags().GetString("boot-root")
-		scanAndSelect(dir, bootRoot)
+		sources, err := selectedSources(cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		scanAndSelect(dir, bootRoot, sources)
 	},
 }
 
-func scanAndSelect(dir, bootRoot string) {
-	entries, err := entry.FindEntries(dir)
+func scanAndSelect(dir, bootRoot string, sources []entry.EntrySource) {
+	entries, err := entry.FindEntriesFrom(dir, sources)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error scanning directory: %v\n", err)
 		os.Exit(1)
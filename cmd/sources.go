@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/timoxa0/kxmenu/entry"
+)
+
+// selectedSources returns the entry sources enabled by the --source and
+// --no-source flags. All registered sources are enabled by default.
+func selectedSources(cmd *cobra.Command) ([]entry.EntrySource, error) {
+	enabled, _ := cmd.Flags().GetStringSlice("source")
+	disabled, _ := cmd.Flags().GetStringSlice("no-source")
+
+	var sources []entry.EntrySource
+	if len(enabled) > 0 {
+		for _, name := range enabled {
+			source, err := entry.LookupSource(name)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, source)
+		}
+	} else {
+		sources = entry.Sources()
+	}
+
+	for _, name := range disabled {
+		if _, err := entry.LookupSource(name); err != nil {
+			return nil, err
+		}
+	}
+
+	var result []entry.EntrySource
+	for _, source := range sources {
+		if !containsString(disabled, source.Name()) {
+			result = append(result, source)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("all entry sources are disabled")
+	}
+
+	return result, nil
+}
+
+// sourceNames returns the names of all registered entry sources
+func sourceNames() string {
+	var names []string
+	for _, source := range entry.Sources() {
+		names = append(names, source.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// stateFile is the name of the state file in the boot entry directory
+const stateFile = "kxmenu.state"
+
+// statePath returns the state file given by the --state flag, by default
+// kxmenu.state in the boot entry directory
+func statePath(cmd *cobra.Command, dir string) string {
+	path, _ := cmd.Flags().GetString("state")
+	if path == "" {
+		path = filepath.Join(dir, stateFile)
+	}
+	return path
+}
@@ -0,0 +1,229 @@
+// Package config reads the kxmenu configuration file, /etc/kxmenu.conf by
+// default. The file holds one setting per line, as a key and a value
+// separated by whitespace. Empty lines and lines starting with # are
+// ignored, and a value may be enclosed in double quotes to keep leading or
+// trailing spaces:
+//
+//	dir             /boot             directory scanned for boot entries
+//	boot-root       /mnt              root directory for boot files
+//	source          bls extlinux      only use these entry sources
+//	no-source       android           disable these entry sources
+//	timeout         5                 menu timeout in seconds
+//	hidden          true              boot the default entry without showing the menu
+//	default         saved             default entry ID, glob pattern or "saved"
+//	state           /var/kxmenu.state state file
+//	overlay-dir     /boot/overlays    devicetree overlays applied to every entry
+//	cmdline-policy  /etc/kxmenu/cmdline.policy
+//	title           "My bootloader"   menu title
+//	footer          "Vol+/- Power"    help text at the bottom of the menu
+//	keymap          de                keyboard layout of the entry editor
+//	theme           mono              built-in color theme
+//	color-title     bold cyan         colors of the menu parts, see menu.ParseColor
+//	color-highlight reverse
+//	color-group     cyan
+//	color-footer    none
+//	kexec-backend   file              how kernels are loaded, see kexec.BackendName
+//	log-file        /run/kxmenu.log   file recording boot decisions
+//	debug           true              print the kexec invocation
+//
+// Each key may appear once. kxmenu.* kernel command line parameters take
+// precedence over command line flags of the same name, flags over the
+// file, and the file over loader.conf and extlinux.conf.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/timoxa0/kxmenu/entry"
+	"github.com/timoxa0/kxmenu/input"
+	"github.com/timoxa0/kxmenu/kexec"
+	"github.com/timoxa0/kxmenu/menu"
+)
+
+// DefaultPath is the configuration file read unless another is given
+const DefaultPath = "/etc/kxmenu.conf"
+
+// Config holds the settings of a configuration file
+type Config struct {
+	Path   string
+	values map[string]string
+	order  []string
+}
+
+// keys are the known settings and the checks their values must pass
+var keys = map[string]func(value string) error{
+	"dir":             nil,
+	"boot-root":       nil,
+	"source":          checkSources,
+	"no-source":       checkSources,
+	"timeout":         checkTimeout,
+	"hidden":          checkBool,
+	"default":         nil,
+	"state":           nil,
+	"overlay-dir":     nil,
+	"cmdline-policy":  nil,
+	"title":           nil,
+	"footer":          nil,
+	"keymap":          input.CheckKeymap,
+	"theme":           checkTheme,
+	"color-title":     checkColor,
+	"color-highlight": checkColor,
+	"color-group":     checkColor,
+	"color-footer":    checkColor,
+	"kexec-backend":   kexec.CheckBackend,
+	"log-file":        nil,
+	"debug":           checkBool,
+}
+
+// Empty returns a configuration without settings
+func Empty() *Config {
+	return &Config{values: make(map[string]string)}
+}
+
+// Load parses a configuration file. All invalid lines are reported, each
+// error naming its line.
+func Load(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cfg := Empty()
+	cfg.Path = path
+	seen := make(map[string]int)
+	var errs []error
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			key, value = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+
+		check, known := keys[key]
+		switch {
+		case !known:
+			errs = append(errs, fmt.Errorf("%s:%d: unknown key %q", path, lineNum, key))
+			continue
+		case seen[key] != 0:
+			errs = append(errs, fmt.Errorf("%s:%d: %s already set on line %d", path, lineNum, key, seen[key]))
+			continue
+		case value == "":
+			errs = append(errs, fmt.Errorf("%s:%d: %s needs a value", path, lineNum, key))
+			continue
+		}
+		if check != nil {
+			if err := check(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s:%d: %s: %v", path, lineNum, key, err))
+				continue
+			}
+		}
+
+		seen[key] = lineNum
+		cfg.values[key] = value
+		cfg.order = append(cfg.order, key)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// Keys returns the keys set, in file order
+func (c *Config) Keys() []string {
+	return c.order
+}
+
+// Get returns the value of a key, or "" if it is not set
+func (c *Config) Get(key string) string {
+	return c.values[key]
+}
+
+// List returns a value holding a list separated by spaces or commas
+func (c *Config) List(key string) []string {
+	return splitList(c.values[key])
+}
+
+// Colors returns theme with the configured colors applied
+func (c *Config) Colors(theme menu.Theme) menu.Theme {
+	colors := map[string]*string{
+		"color-title":     &theme.Title,
+		"color-highlight": &theme.Highlight,
+		"color-group":     &theme.Group,
+		"color-footer":    &theme.Footer,
+	}
+	for key, color := range colors {
+		if spec, ok := c.values[key]; ok {
+			*color, _ = menu.ParseColor(spec)
+		}
+	}
+	return theme
+}
+
+// splitList splits a list separated by spaces or commas
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ','
+	})
+}
+
+// checkSources checks a list of entry source names
+func checkSources(value string) error {
+	for _, name := range splitList(value) {
+		if _, err := entry.LookupSource(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTimeout checks a timeout in seconds
+func checkTimeout(value string) error {
+	timeout, err := strconv.Atoi(value)
+	if err != nil || timeout < 0 {
+		return fmt.Errorf("invalid timeout %q, want seconds", value)
+	}
+	return nil
+}
+
+// checkTheme checks a built-in theme name
+func checkTheme(value string) error {
+	_, err := menu.LookupTheme(value)
+	return err
+}
+
+// checkColor checks a color specification
+func checkColor(value string) error {
+	_, err := menu.ParseColor(value)
+	return err
+}
+
+// checkBool checks a boolean value
+func checkBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("invalid boolean %q", value)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Package decompress unpacks compressed kernel images. The format is
+// detected from magic bytes, as the kernel's own decompressors do, and
+// data following the compressed stream, such as the size trailer of the
+// kernel build, is ignored.
+package decompress
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Format is a compression format
+type Format string
+
+const (
+	Uncompressed Format = "uncompressed"
+	Gzip         Format = "gzip"
+	Zstd         Format = "zstd"
+	XZ           Format = "xz"
+	LZMA         Format = "lzma"
+	LZ4          Format = "lz4"
+	LZ4Legacy    Format = "lz4-legacy"
+	Bzip2        Format = "bzip2"
+)
+
+// formats are the compressed formats with their magic bytes, checked in
+// order
+var formats = []struct {
+	format Format
+	magic  []byte
+	decode func(data []byte) ([]byte, error)
+}{
+	{Gzip, []byte{0x1f, 0x8b}, decodeGzip},
+	{Zstd, []byte{0x28, 0xb5, 0x2f, 0xfd}, decodeZstd},
+	{XZ, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, decodeXZ},
+	{LZ4, []byte{0x04, 0x22, 0x4d, 0x18}, decodeLZ4Frame},
+	{LZ4Legacy, []byte{0x02, 0x21, 0x4c, 0x18}, decodeLZ4Legacy},
+	{Bzip2, []byte{'B', 'Z', 'h'}, decodeBzip2},
+	{LZMA, []byte{0x5d, 0x00, 0x00}, decodeLZMA},
+}
+
+// IsKernelImage checks for kernel images that are loaded as they are: ELF
+// files, arm64 and RISC-V Image files and x86 bzImages
+func IsKernelImage(data []byte) bool {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x7fELF")):
+		return true
+	case len(data) >= 0x40 && string(data[0x38:0x3c]) == "ARM\x64":
+		return true
+	case len(data) >= 0x40 && string(data[0x38:0x3c]) == "RSC\x05":
+		return true
+	case len(data) >= 0x206 && binary.LittleEndian.Uint16(data[0x1fe:]) == 0xaa55 && string(data[0x202:0x206]) == "HdrS":
+		return true
+	}
+	return false
+}
+
+// Detect returns the compression format of data. Kernel images and data
+// in no known format are Uncompressed.
+func Detect(data []byte) Format {
+	if IsKernelImage(data) {
+		return Uncompressed
+	}
+	for _, f := range formats {
+		if bytes.HasPrefix(data, f.magic) {
+			return f.format
+		}
+	}
+	return Uncompressed
+}
+
+// Decompress returns data decompressed and its format. Uncompressed data
+// is returned as it is.
+func Decompress(data []byte) ([]byte, Format, error) {
+	format := Detect(data)
+	for _, f := range formats {
+		if f.format == format {
+			out, err := f.decode(data)
+			if err != nil {
+				return nil, format, fmt.Errorf("%s: %v", format, err)
+			}
+			return out, format, nil
+		}
+	}
+	return data, Uncompressed, nil
+}
+
+// decodeGzip decodes the first gzip member
+func decodeGzip(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	reader.Multistream(false)
+	return io.ReadAll(reader)
+}
+
+// decodeBzip2 decodes bzip2 streams. The reader takes data after a
+// stream for another one, so a failure to find one ends the data.
+func decodeBzip2(data []byte) ([]byte, error) {
+	out, err := io.ReadAll(bzip2.NewReader(bytes.NewReader(data)))
+	if err == bzip2.StructuralError("bad magic value in continuation file") {
+		return out, nil
+	}
+	return out, err
+}
@@ -0,0 +1,199 @@
+package decompress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fixtures are the compressed copies of testdata/input and their formats,
+// made with the gzip, zstd, xz, lz4 and bzip2 tools. The xz fixtures cover
+// each check type and the x86 and arm64 branch filters.
+var fixtures = []struct {
+	name   string
+	format Format
+}{
+	{"input.gz", Gzip},
+	{"input.zst", Zstd},
+	{"input.19.zst", Zstd},
+	{"input.xz", XZ},
+	{"input.crc32.xz", XZ},
+	{"input.sha256.xz", XZ},
+	{"input.none.xz", XZ},
+	{"input.x86.xz", XZ},
+	{"input.arm64.xz", XZ},
+	{"input.lzma", LZMA},
+	{"input.lz4", LZ4},
+	{"input.bx.lz4", LZ4},
+	{"input.legacy.lz4", LZ4Legacy},
+	{"input.bz2", Bzip2},
+}
+
+func readFixture(t testing.TB, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecompress(t *testing.T) {
+	want := readFixture(t, "input")
+
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			data := readFixture(t, f.name)
+			if format := Detect(data); format != f.format {
+				t.Fatalf("Detect() = %s, want %s", format, f.format)
+			}
+
+			out, format, err := Decompress(data)
+			if err != nil {
+				t.Fatalf("Decompress() error: %v", err)
+			}
+			if format != f.format {
+				t.Errorf("Decompress() format = %s, want %s", format, f.format)
+			}
+			if !bytes.Equal(out, want) {
+				t.Errorf("Decompress() returned %d bytes differing from the input", len(out))
+			}
+		})
+	}
+}
+
+// TestDecompressTrailer checks that data after the stream is ignored, like
+// the size trailer the kernel build appends
+func TestDecompressTrailer(t *testing.T) {
+	want := readFixture(t, "input")
+
+	sizeTrailer := binary.LittleEndian.AppendUint32(nil, uint32(len(want)))
+	trailers := map[string][]byte{
+		"size":    sizeTrailer,
+		"zeros":   make([]byte, 512),
+		"garbage": []byte("trailing garbage after the stream"),
+	}
+
+	for _, f := range fixtures {
+		for name, trailer := range trailers {
+			t.Run(f.name+"/"+name, func(t *testing.T) {
+				data := append(readFixture(t, f.name), trailer...)
+				out, _, err := Decompress(data)
+				if err != nil {
+					t.Fatalf("Decompress() error: %v", err)
+				}
+				if !bytes.Equal(out, want) {
+					t.Errorf("Decompress() returned %d bytes differing from the input", len(out))
+				}
+			})
+		}
+	}
+}
+
+// TestDecompressTruncated checks that cut streams fail rather than return
+// short output. The legacy lz4 format has no end mark, so a cut stream
+// ends at the last complete block, which is none here.
+func TestDecompressTruncated(t *testing.T) {
+	want := readFixture(t, "input")
+
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			data := readFixture(t, f.name)
+			for _, size := range []int{16, len(data) / 2, len(data) - 1} {
+				out, _, err := Decompress(data[:size])
+				if err == nil && !bytes.Equal(out, want) && !(f.format == LZ4Legacy && len(out) == 0) {
+					t.Errorf("Decompress() of %d bytes returned %d bytes without an error", size, len(out))
+				}
+			}
+		})
+	}
+}
+
+// TestDecompressDeclaredSize checks that the size a header declares is
+// not allocated before the data backs it
+func TestDecompressDeclaredSize(t *testing.T) {
+	data := make([]byte, 32)
+	copy(data, []byte{0x5d, 0x00, 0x00, 0x80, 0x00})
+	binary.LittleEndian.PutUint64(data[5:], 1<<32)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, _, err := Decompress(data); err == nil {
+		t.Errorf("Decompress() of a short stream succeeded")
+	}
+	runtime.ReadMemStats(&after)
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("Decompress() of %d bytes allocated %d MiB", len(data), allocated>>20)
+	}
+}
+
+// kernelHeader returns a kernel image header with the given bytes set at
+// their offsets. Its first bytes mimic a compression magic, which must
+// not be taken for one.
+func kernelHeader(size int, fields map[int]string) []byte {
+	data := make([]byte, size)
+	copy(data, []byte{0x5d, 0x00, 0x00, 0x00})
+	for offset, value := range fields {
+		copy(data[offset:], value)
+	}
+	return data
+}
+
+func TestKernelImagePassThrough(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"elf", append([]byte("\x7fELF\x02\x01\x01"), make([]byte, 57)...)},
+		{"arm64", kernelHeader(0x40, map[int]string{0x38: "ARM\x64"})},
+		{"riscv", kernelHeader(0x40, map[int]string{0x38: "RSC\x05"})},
+		{"bzimage", kernelHeader(0x400, map[int]string{0x1fe: "\x55\xaa", 0x202: "HdrS"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !IsKernelImage(tt.data) {
+				t.Fatalf("IsKernelImage() = false")
+			}
+			if format := Detect(tt.data); format != Uncompressed {
+				t.Errorf("Detect() = %s, want %s", format, Uncompressed)
+			}
+			out, format, err := Decompress(tt.data)
+			if err != nil || format != Uncompressed || !bytes.Equal(out, tt.data) {
+				t.Errorf("Decompress() = %d bytes, %s, %v, want the data unchanged", len(out), format, err)
+			}
+		})
+	}
+
+	// Cut headers are no kernel images
+	for _, data := range [][]byte{
+		kernelHeader(0x3c, map[int]string{0x38: "ARM\x64"}),
+		kernelHeader(0x204, map[int]string{0x1fe: "\x55\xaa", 0x202: "Hd"}),
+	} {
+		if IsKernelImage(data) {
+			t.Errorf("IsKernelImage() of a %d byte header = true", len(data))
+		}
+	}
+}
+
+func FuzzDecompress(f *testing.F) {
+	for _, fixture := range fixtures {
+		data := readFixture(f, fixture.name)
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out, format, err := Decompress(data)
+		if format != Detect(data) {
+			t.Errorf("Decompress() format = %s, Detect() = %s", format, Detect(data))
+		}
+		if format == Uncompressed && (err != nil || !bytes.Equal(out, data)) {
+			t.Errorf("Decompress() changed uncompressed data")
+		}
+	})
+}
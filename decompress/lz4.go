@@ -0,0 +1,209 @@
+package decompress
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// lz4 frame format constants
+const (
+	lz4FrameMagic     = 0x184d2204
+	lz4LegacyMagic    = 0x184c2102
+	lz4SkippableMagic = 0x184d2a50 // Low four bits vary
+	lz4FlagVersion    = 0xc0
+	lz4FlagBlockSum   = 0x10
+	lz4FlagSize       = 0x08
+	lz4FlagContentSum = 0x04
+	lz4FlagDictID     = 0x01
+	lz4Uncompressed   = 0x80000000
+	lz4LegacyBlock    = 8 << 20 // Decompressed size of a legacy block
+)
+
+// decodeLZ4Frame decodes lz4 frames until data ends or holds no further
+// frame
+func decodeLZ4Frame(data []byte) ([]byte, error) {
+	var out []byte
+	pos := 0
+
+	for pos+4 <= len(data) {
+		magic := binary.LittleEndian.Uint32(data[pos:])
+		switch {
+		case magic&0xfffffff0 == lz4SkippableMagic:
+			if pos+8 > len(data) {
+				return nil, fmt.Errorf("truncated skippable frame")
+			}
+			pos += 8 + int(binary.LittleEndian.Uint32(data[pos+4:]))
+			continue
+		case magic != lz4FrameMagic:
+			return out, nil
+		}
+		pos += 4
+
+		if pos+2 > len(data) {
+			return nil, fmt.Errorf("truncated frame header")
+		}
+		flags := data[pos]
+		if flags&lz4FlagVersion != 0x40 {
+			return nil, fmt.Errorf("unsupported frame version")
+		}
+		if flags&lz4FlagDictID != 0 {
+			return nil, fmt.Errorf("dictionaries are not supported")
+		}
+		pos += 2 // Flags and block descriptor
+		if flags&lz4FlagSize != 0 {
+			pos += 8
+		}
+		pos++ // Header checksum
+
+		for {
+			if pos+4 > len(data) {
+				return nil, fmt.Errorf("truncated block")
+			}
+			size := binary.LittleEndian.Uint32(data[pos:])
+			pos += 4
+			if size == 0 {
+				break // End mark
+			}
+
+			n := int(size &^ lz4Uncompressed)
+			if pos+n > len(data) {
+				return nil, fmt.Errorf("truncated block")
+			}
+			block := data[pos : pos+n]
+			pos += n
+			if flags&lz4FlagBlockSum != 0 {
+				pos += 4
+			}
+
+			if size&lz4Uncompressed != 0 {
+				out = append(out, block...)
+				continue
+			}
+			// Blocks may refer back to earlier blocks, which stay in out
+			var err error
+			out, err = decodeLZ4Block(out, block)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if flags&lz4FlagContentSum != 0 {
+			pos += 4
+		}
+	}
+	return out, nil
+}
+
+// decodeLZ4Legacy decodes the legacy lz4 format used by the kernel build.
+// It has no end mark, so a block size that does not fit the remaining
+// data ends the stream.
+func decodeLZ4Legacy(data []byte) ([]byte, error) {
+	var out []byte
+	pos := 4
+
+	for pos+4 <= len(data) {
+		size := binary.LittleEndian.Uint32(data[pos:])
+		if size == lz4LegacyMagic {
+			pos += 4 // Concatenated stream
+			continue
+		}
+		if int64(pos)+4+int64(size) > int64(len(data)) {
+			break // Trailing data
+		}
+		pos += 4
+
+		start := len(out)
+		var err error
+		out, err = decodeLZ4Block(out, data[pos:pos+int(size)])
+		if err != nil {
+			return nil, err
+		}
+		if len(out)-start > lz4LegacyBlock {
+			return nil, fmt.Errorf("block larger than %d bytes", lz4LegacyBlock)
+		}
+		pos += int(size)
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no data")
+	}
+	return out, nil
+}
+
+// decodeLZ4Block appends a decoded lz4 block to out. Matches may reach
+// back into earlier output.
+func decodeLZ4Block(out, src []byte) ([]byte, error) {
+	pos := 0
+	for pos < len(src) {
+		token := src[pos]
+		pos++
+
+		// Literals
+		literals := int(token >> 4)
+		if literals == 15 {
+			for {
+				if pos >= len(src) {
+					return nil, fmt.Errorf("truncated literal length")
+				}
+				b := src[pos]
+				pos++
+				literals += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if pos+literals > len(src) {
+			return nil, fmt.Errorf("truncated literals")
+		}
+		out = append(out, src[pos:pos+literals]...)
+		pos += literals
+
+		// The last sequence has literals only
+		if pos == len(src) {
+			break
+		}
+
+		// Match
+		if pos+2 > len(src) {
+			return nil, fmt.Errorf("truncated match offset")
+		}
+		offset := int(binary.LittleEndian.Uint16(src[pos:]))
+		pos += 2
+		if offset == 0 || offset > len(out) {
+			return nil, fmt.Errorf("invalid match offset %d", offset)
+		}
+
+		length := int(token & 15)
+		if length == 15 {
+			for {
+				if pos >= len(src) {
+					return nil, fmt.Errorf("truncated match length")
+				}
+				b := src[pos]
+				pos++
+				length += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		length += 4
+
+		out = copyMatch(out, offset, length)
+	}
+	return out, nil
+}
+
+// copyMatch appends length bytes starting offset bytes back in out. The
+// ranges may overlap, repeating the copied bytes.
+func copyMatch(out []byte, offset, length int) []byte {
+	start := len(out) - offset
+	if offset >= length {
+		return append(out, out[start:start+length]...)
+	}
+	for i := 0; i < length; i++ {
+		out = append(out, out[start+i])
+	}
+	return out
+}
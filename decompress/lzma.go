@@ -0,0 +1,402 @@
+package decompress
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// LZMA model constants
+const (
+	lzmaStates          = 12
+	lzmaPosBitsMax      = 4
+	lzmaLenLowBits      = 3
+	lzmaLenMidBits      = 3
+	lzmaLenHighBits     = 8
+	lzmaMatchMinLen     = 2
+	lzmaLenToPosStates  = 4
+	lzmaPosSlotBits     = 6
+	lzmaStartPosModel   = 4
+	lzmaEndPosModel     = 14
+	lzmaFullDistances   = 1 << (lzmaEndPosModel >> 1)
+	lzmaAlignBits       = 4
+	lzmaLiteralCoderLen = 0x300
+	lzmaProbInit        = 1 << 10
+	lzmaEndMarker       = 0xffffffff
+)
+
+// rangeDecoder is the LZMA range decoder
+type rangeDecoder struct {
+	data  []byte
+	pos   int
+	rng   uint32
+	code  uint32
+	short bool // Read past the end of data
+}
+
+// init starts decoding at data[pos]
+func (rc *rangeDecoder) init(data []byte, pos int) error {
+	if pos+5 > len(data) {
+		return fmt.Errorf("truncated range coder data")
+	}
+	if data[pos] != 0 {
+		return fmt.Errorf("invalid range coder data")
+	}
+	rc.data = data
+	rc.rng = 0xffffffff
+	rc.code = binary.BigEndian.Uint32(data[pos+1:])
+	rc.pos = pos + 5
+	rc.short = false
+	return nil
+}
+
+func (rc *rangeDecoder) normalize() {
+	if rc.rng < 1<<24 {
+		rc.rng <<= 8
+		if rc.pos < len(rc.data) {
+			rc.code = rc.code<<8 | uint32(rc.data[rc.pos])
+		} else {
+			rc.code <<= 8
+			rc.short = true
+		}
+		rc.pos++
+	}
+}
+
+// bit decodes a bit with an adaptive probability
+func (rc *rangeDecoder) bit(prob *uint16) uint32 {
+	rc.normalize()
+	bound := (rc.rng >> 11) * uint32(*prob)
+	if rc.code < bound {
+		rc.rng = bound
+		*prob += (1<<11 - *prob) >> 5
+		return 0
+	}
+	rc.rng -= bound
+	rc.code -= bound
+	*prob -= *prob >> 5
+	return 1
+}
+
+// direct decodes bits of fixed, even probability
+func (rc *rangeDecoder) direct(count uint) uint32 {
+	var result uint32
+	for ; count > 0; count-- {
+		rc.normalize()
+		rc.rng >>= 1
+		result <<= 1
+		if rc.code >= rc.rng {
+			rc.code -= rc.rng
+			result |= 1
+		}
+	}
+	return result
+}
+
+// bitTree decodes a symbol of bits bits, most significant first
+func (rc *rangeDecoder) bitTree(probs []uint16, bits uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < bits; i++ {
+		m = m<<1 | rc.bit(&probs[m])
+	}
+	return m - 1<<bits
+}
+
+// reverseBitTree decodes a symbol of bits bits, least significant first
+func (rc *rangeDecoder) reverseBitTree(probs []uint16, bits uint) uint32 {
+	m := uint32(1)
+	var symbol uint32
+	for i := uint(0); i < bits; i++ {
+		bit := rc.bit(&probs[m])
+		m = m<<1 | bit
+		symbol |= bit << i
+	}
+	return symbol
+}
+
+// lenDecoder decodes match lengths
+type lenDecoder struct {
+	choice  uint16
+	choice2 uint16
+	low     [1 << lzmaPosBitsMax][1 << lzmaLenLowBits]uint16
+	mid     [1 << lzmaPosBitsMax][1 << lzmaLenMidBits]uint16
+	high    [1 << lzmaLenHighBits]uint16
+}
+
+func (ld *lenDecoder) reset() {
+	ld.choice, ld.choice2 = lzmaProbInit, lzmaProbInit
+	for i := range ld.low {
+		fillProbs(ld.low[i][:])
+		fillProbs(ld.mid[i][:])
+	}
+	fillProbs(ld.high[:])
+}
+
+func (ld *lenDecoder) decode(rc *rangeDecoder, posState uint32) uint32 {
+	if rc.bit(&ld.choice) == 0 {
+		return rc.bitTree(ld.low[posState][:], lzmaLenLowBits) + lzmaMatchMinLen
+	}
+	if rc.bit(&ld.choice2) == 0 {
+		return rc.bitTree(ld.mid[posState][:], lzmaLenMidBits) + lzmaMatchMinLen + 1<<lzmaLenLowBits
+	}
+	return rc.bitTree(ld.high[:], lzmaLenHighBits) + lzmaMatchMinLen + 1<<lzmaLenLowBits + 1<<lzmaLenMidBits
+}
+
+// lzmaDecoder decodes LZMA data into a growing output buffer, which is
+// also the dictionary
+type lzmaDecoder struct {
+	rc        rangeDecoder
+	lc, lp    uint
+	pb        uint
+	out       []byte
+	dictStart int    // Start of the dictionary in out
+	pending   uint32 // Match bytes still to copy when a chunk ended
+	state     uint32
+	rep       [4]uint32
+
+	literal    []uint16
+	isMatch    [lzmaStates << lzmaPosBitsMax]uint16
+	isRep      [lzmaStates]uint16
+	isRepG0    [lzmaStates]uint16
+	isRepG1    [lzmaStates]uint16
+	isRepG2    [lzmaStates]uint16
+	isRep0Long [lzmaStates << lzmaPosBitsMax]uint16
+	posSlot    [lzmaLenToPosStates][1 << lzmaPosSlotBits]uint16
+	posSpecial [lzmaFullDistances - lzmaEndPosModel + 1]uint16 // Unused first entry
+	align      [1 << lzmaAlignBits]uint16
+	matchLen   lenDecoder
+	repLen     lenDecoder
+}
+
+// setProperties sets lc, lp and pb from an LZMA properties byte
+func (d *lzmaDecoder) setProperties(props byte) error {
+	if props >= 9*5*5 {
+		return fmt.Errorf("invalid LZMA properties")
+	}
+	d.lc = uint(props % 9)
+	props /= 9
+	d.lp = uint(props % 5)
+	d.pb = uint(props / 5)
+	return nil
+}
+
+// resetState resets the probabilities and the coder state
+func (d *lzmaDecoder) resetState() {
+	size := lzmaLiteralCoderLen << (d.lc + d.lp)
+	if cap(d.literal) >= size {
+		d.literal = d.literal[:size]
+	} else {
+		d.literal = make([]uint16, size)
+	}
+	fillProbs(d.literal)
+	fillProbs(d.isMatch[:])
+	fillProbs(d.isRep[:])
+	fillProbs(d.isRepG0[:])
+	fillProbs(d.isRepG1[:])
+	fillProbs(d.isRepG2[:])
+	fillProbs(d.isRep0Long[:])
+	for i := range d.posSlot {
+		fillProbs(d.posSlot[i][:])
+	}
+	fillProbs(d.posSpecial[:])
+	fillProbs(d.align[:])
+	d.matchLen.reset()
+	d.repLen.reset()
+	d.state = 0
+	d.rep = [4]uint32{}
+	d.pending = 0
+}
+
+// decode decodes until out holds limit bytes or the end marker is found,
+// and reports whether the end marker was found. A limit below zero
+// decodes up to the end marker.
+func (d *lzmaDecoder) decode(limit int) (bool, error) {
+	rc := &d.rc
+	pbMask := uint32(1)<<d.pb - 1
+	lpMask := uint32(1)<<d.lp - 1
+
+	if d.pending > 0 {
+		if err := d.copy(limit); err != nil {
+			return false, err
+		}
+	}
+
+	for limit < 0 || len(d.out) < limit {
+		if rc.short {
+			return false, fmt.Errorf("truncated LZMA data")
+		}
+
+		pos := uint32(len(d.out) - d.dictStart)
+		posState := pos & pbMask
+
+		if rc.bit(&d.isMatch[d.state<<lzmaPosBitsMax+posState]) == 0 {
+			d.decodeLiteral(pos, lpMask)
+			continue
+		}
+
+		var length uint32
+		if rc.bit(&d.isRep[d.state]) == 0 {
+			// Match with a new distance
+			length = d.matchLen.decode(rc, posState)
+			d.state = updateState(d.state, 7, 10)
+			distance := d.decodeDistance(length)
+			if distance == lzmaEndMarker {
+				return true, nil
+			}
+			d.rep = [4]uint32{distance, d.rep[0], d.rep[1], d.rep[2]}
+		} else {
+			// Match with a recent distance
+			if rc.bit(&d.isRepG0[d.state]) == 0 {
+				if rc.bit(&d.isRep0Long[d.state<<lzmaPosBitsMax+posState]) == 0 {
+					d.state = updateState(d.state, 9, 11)
+					d.pending = 1
+					if err := d.copy(limit); err != nil {
+						return false, err
+					}
+					continue
+				}
+			} else {
+				var distance uint32
+				if rc.bit(&d.isRepG1[d.state]) == 0 {
+					distance = d.rep[1]
+				} else {
+					if rc.bit(&d.isRepG2[d.state]) == 0 {
+						distance = d.rep[2]
+					} else {
+						distance = d.rep[3]
+						d.rep[3] = d.rep[2]
+					}
+					d.rep[2] = d.rep[1]
+				}
+				d.rep[1] = d.rep[0]
+				d.rep[0] = distance
+			}
+			length = d.repLen.decode(rc, posState)
+			d.state = updateState(d.state, 8, 11)
+		}
+
+		d.pending = length
+		if err := d.copy(limit); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// updateState moves the state after a match, to literalNext after a
+// literal and to matchNext after a match
+func updateState(state, literalNext, matchNext uint32) uint32 {
+	if state < 7 {
+		return literalNext
+	}
+	return matchNext
+}
+
+// decodeLiteral decodes one byte
+func (d *lzmaDecoder) decodeLiteral(pos, lpMask uint32) {
+	rc := &d.rc
+	var prev uint32
+	if len(d.out) > d.dictStart {
+		prev = uint32(d.out[len(d.out)-1])
+	}
+	base := lzmaLiteralCoderLen * ((pos&lpMask)<<d.lc + prev>>(8-d.lc))
+	probs := d.literal[base : base+lzmaLiteralCoderLen]
+
+	symbol := uint32(1)
+	if d.state >= 7 {
+		// After a match the byte at rep0 guides the decoding
+		matchByte := uint32(d.out[len(d.out)-int(d.rep[0])-1])
+		for symbol < 0x100 {
+			matchBit := (matchByte >> 7) & 1
+			matchByte <<= 1
+			bit := rc.bit(&probs[0x100+matchBit<<8+symbol])
+			symbol = symbol<<1 | bit
+			if bit != matchBit {
+				break
+			}
+		}
+	}
+	for symbol < 0x100 {
+		symbol = symbol<<1 | rc.bit(&probs[symbol])
+	}
+	d.out = append(d.out, byte(symbol))
+
+	switch {
+	case d.state < 4:
+		d.state = 0
+	case d.state < 10:
+		d.state -= 3
+	default:
+		d.state -= 6
+	}
+}
+
+// decodeDistance decodes the distance of a match of the given length
+func (d *lzmaDecoder) decodeDistance(length uint32) uint32 {
+	rc := &d.rc
+	lenState := min(length-lzmaMatchMinLen, lzmaLenToPosStates-1)
+	slot := rc.bitTree(d.posSlot[lenState][:], lzmaPosSlotBits)
+	if slot < lzmaStartPosModel {
+		return slot
+	}
+
+	bits := uint(slot>>1) - 1
+	distance := (2 | slot&1) << bits
+	if slot < lzmaEndPosModel {
+		return distance + rc.reverseBitTree(d.posSpecial[distance-slot:], bits)
+	}
+	distance += rc.direct(bits-lzmaAlignBits) << lzmaAlignBits
+	return distance + rc.reverseBitTree(d.align[:], lzmaAlignBits)
+}
+
+// copy copies pending match bytes from rep0 back, stopping at limit
+func (d *lzmaDecoder) copy(limit int) error {
+	distance := int(d.rep[0]) + 1
+	if distance > len(d.out)-d.dictStart {
+		return fmt.Errorf("match distance %d beyond the dictionary", distance)
+	}
+	n := int(d.pending)
+	if limit >= 0 {
+		n = min(n, limit-len(d.out))
+	}
+	d.out = copyMatch(d.out, distance, n)
+	d.pending -= uint32(n)
+	return nil
+}
+
+// fillProbs sets probabilities to their initial value of one half
+func fillProbs(probs []uint16) {
+	for i := range probs {
+		probs[i] = lzmaProbInit
+	}
+}
+
+// decodeLZMA decodes the .lzma format: a properties byte, the dictionary
+// size, the uncompressed size or -1 if the end marker ends the data, and
+// the LZMA data
+func decodeLZMA(data []byte) ([]byte, error) {
+	if len(data) < 13 {
+		return nil, fmt.Errorf("truncated header")
+	}
+
+	d := &lzmaDecoder{}
+	if err := d.setProperties(data[0]); err != nil {
+		return nil, err
+	}
+	d.resetState()
+
+	limit := -1
+	if size := binary.LittleEndian.Uint64(data[5:]); size != 1<<64-1 {
+		if size > 1<<32 {
+			return nil, fmt.Errorf("uncompressed size %d is too large", size)
+		}
+		limit = int(size) // Not trusted for allocation, the data may be short
+	}
+
+	if err := d.rc.init(data, 13); err != nil {
+		return nil, err
+	}
+	if _, err := d.decode(limit); err != nil {
+		return nil, err
+	}
+	return d.out, nil
+}
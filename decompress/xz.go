@@ -0,0 +1,344 @@
+package decompress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+)
+
+// xz container constants
+const (
+	xzHeaderSize   = 12
+	xzCheckNone    = 0x00
+	xzCheckCRC32   = 0x01
+	xzCheckCRC64   = 0x04
+	xzCheckSHA256  = 0x0a
+	xzFilterX86    = 0x04
+	xzFilterARM64  = 0x0a
+	xzFilterLZMA2  = 0x21
+	xzMaxFilters   = 4
+	xzBlockHasComp = 0x40
+	xzBlockHasSize = 0x80
+)
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// xzFilter is a filter of an xz block
+type xzFilter struct {
+	id    uint64
+	props []byte
+}
+
+// decodeXZ decodes the blocks of an xz stream, verifying their checks.
+// Decoding stops at the stream index, ignoring anything after it.
+func decodeXZ(data []byte) ([]byte, error) {
+	if len(data) < xzHeaderSize {
+		return nil, fmt.Errorf("truncated stream header")
+	}
+	if data[6] != 0 || crc32.ChecksumIEEE(data[6:8]) != binary.LittleEndian.Uint32(data[8:]) {
+		return nil, fmt.Errorf("invalid stream header")
+	}
+	check := data[7]
+
+	var out []byte
+	pos := xzHeaderSize
+	for {
+		if pos >= len(data) {
+			return nil, fmt.Errorf("truncated stream")
+		}
+		if data[pos] == 0 {
+			return out, nil // Index
+		}
+
+		blockStart := len(out)
+		var filters []xzFilter
+		var err error
+		filters, pos, err = parseXZBlockHeader(data, pos)
+		if err != nil {
+			return nil, err
+		}
+
+		lzma2Start := pos
+		out, pos, err = decodeLZMA2(data, pos, out)
+		if err != nil {
+			return nil, err
+		}
+		pos += (4 - (pos-lzma2Start)%4) % 4 // Block padding
+
+		block := out[blockStart:]
+		for i := len(filters) - 2; i >= 0; i-- {
+			applyBCJ(filters[i], block)
+		}
+
+		pos, err = verifyXZCheck(data, pos, check, block)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseXZBlockHeader parses a block header at pos and returns the block's
+// filters and the position of its data
+func parseXZBlockHeader(data []byte, pos int) ([]xzFilter, int, error) {
+	size := (int(data[pos]) + 1) * 4
+	if pos+size > len(data) {
+		return nil, 0, fmt.Errorf("truncated block header")
+	}
+	header := data[pos : pos+size]
+	if crc32.ChecksumIEEE(header[:size-4]) != binary.LittleEndian.Uint32(header[size-4:]) {
+		return nil, 0, fmt.Errorf("block header checksum mismatch")
+	}
+
+	flags := header[1]
+	r := bytes.NewReader(header[2 : size-4])
+	if flags&xzBlockHasComp != 0 {
+		if _, err := binary.ReadUvarint(r); err != nil {
+			return nil, 0, fmt.Errorf("invalid block header")
+		}
+	}
+	if flags&xzBlockHasSize != 0 {
+		if _, err := binary.ReadUvarint(r); err != nil {
+			return nil, 0, fmt.Errorf("invalid block header")
+		}
+	}
+
+	count := int(flags&0x03) + 1
+	filters := make([]xzFilter, count)
+	for i := range filters {
+		id, err1 := binary.ReadUvarint(r)
+		propsLen, err2 := binary.ReadUvarint(r)
+		if err1 != nil || err2 != nil || propsLen > uint64(r.Len()) {
+			return nil, 0, fmt.Errorf("invalid block header")
+		}
+		props := make([]byte, propsLen)
+		r.Read(props)
+		filters[i] = xzFilter{id: id, props: props}
+	}
+
+	if filters[count-1].id != xzFilterLZMA2 {
+		return nil, 0, fmt.Errorf("last filter is 0x%x, not LZMA2", filters[count-1].id)
+	}
+	for _, f := range filters[:count-1] {
+		if f.id != xzFilterX86 && f.id != xzFilterARM64 {
+			return nil, 0, fmt.Errorf("unsupported filter 0x%x", f.id)
+		}
+		if len(f.props) != 0 {
+			return nil, 0, fmt.Errorf("BCJ start offsets are not supported")
+		}
+	}
+	return filters, pos + size, nil
+}
+
+// verifyXZCheck compares the check of a block at pos with its data and
+// returns the position after it
+func verifyXZCheck(data []byte, pos int, check byte, block []byte) (int, error) {
+	var sum []byte
+	switch check {
+	case xzCheckNone:
+	case xzCheckCRC32:
+		sum = binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(block))
+	case xzCheckCRC64:
+		sum = binary.LittleEndian.AppendUint64(nil, crc64.Checksum(block, crc64Table))
+	case xzCheckSHA256:
+		digest := sha256.Sum256(block)
+		sum = digest[:]
+	default:
+		return 0, fmt.Errorf("unsupported check type 0x%x", check)
+	}
+
+	if pos+len(sum) > len(data) {
+		return 0, fmt.Errorf("truncated block check")
+	}
+	if !bytes.Equal(data[pos:pos+len(sum)], sum) {
+		return 0, fmt.Errorf("block check mismatch")
+	}
+	return pos + len(sum), nil
+}
+
+// decodeLZMA2 decodes LZMA2 chunks at pos, appending to out, and returns
+// the output and the position after the end chunk
+func decodeLZMA2(data []byte, pos int, out []byte) ([]byte, int, error) {
+	d := &lzmaDecoder{out: out, dictStart: len(out)}
+	needProps := true
+
+	for {
+		if pos >= len(data) {
+			return nil, 0, fmt.Errorf("truncated LZMA2 data")
+		}
+		control := data[pos]
+		pos++
+
+		switch {
+		case control == 0x00:
+			return d.out, pos, nil
+
+		case control == 0x01 || control == 0x02:
+			// Uncompressed chunk, 0x01 resets the dictionary
+			if pos+2 > len(data) {
+				return nil, 0, fmt.Errorf("truncated LZMA2 chunk")
+			}
+			size := int(binary.BigEndian.Uint16(data[pos:])) + 1
+			pos += 2
+			if pos+size > len(data) {
+				return nil, 0, fmt.Errorf("truncated LZMA2 chunk")
+			}
+			if control == 0x01 {
+				d.dictStart = len(d.out)
+			}
+			d.out = append(d.out, data[pos:pos+size]...)
+			pos += size
+
+		case control >= 0x80:
+			if pos+4 > len(data) {
+				return nil, 0, fmt.Errorf("truncated LZMA2 chunk")
+			}
+			unpacked := int(control&0x1f)<<16 + int(binary.BigEndian.Uint16(data[pos:])) + 1
+			packed := int(binary.BigEndian.Uint16(data[pos+2:])) + 1
+			pos += 4
+
+			reset := (control >> 5) & 0x03
+			if reset == 3 {
+				d.dictStart = len(d.out)
+			}
+			if reset >= 2 {
+				if pos >= len(data) {
+					return nil, 0, fmt.Errorf("truncated LZMA2 chunk")
+				}
+				if err := d.setProperties(data[pos]); err != nil {
+					return nil, 0, err
+				}
+				if d.lc+d.lp > 4 {
+					return nil, 0, fmt.Errorf("invalid LZMA2 properties")
+				}
+				pos++
+				needProps = false
+			}
+			if needProps {
+				return nil, 0, fmt.Errorf("LZMA2 chunk without properties")
+			}
+			if reset >= 1 {
+				d.resetState()
+			}
+
+			if pos+packed > len(data) {
+				return nil, 0, fmt.Errorf("truncated LZMA2 chunk")
+			}
+			if err := d.rc.init(data[:pos+packed], pos); err != nil {
+				return nil, 0, err
+			}
+			ended, err := d.decode(len(d.out) + unpacked)
+			if err != nil {
+				return nil, 0, err
+			}
+			if ended {
+				return nil, 0, fmt.Errorf("end marker in LZMA2 chunk")
+			}
+			pos += packed
+
+		default:
+			return nil, 0, fmt.Errorf("invalid LZMA2 control byte 0x%02x", control)
+		}
+	}
+}
+
+// applyBCJ undoes a branch converter filter in place
+func applyBCJ(filter xzFilter, buf []byte) {
+	switch filter.id {
+	case xzFilterX86:
+		bcjX86(buf)
+	case xzFilterARM64:
+		bcjARM64(buf)
+	}
+}
+
+// bcjX86 converts the absolute addresses of x86 CALL and JMP instructions
+// back to relative ones
+func bcjX86(buf []byte) {
+	maskToAllowed := [8]bool{true, true, true, false, true, false, false, false}
+	maskToBitNum := [8]uint32{0, 1, 2, 2, 3, 3, 3, 3}
+	testMSByte := func(b byte) bool { return b == 0x00 || b == 0xff }
+
+	if len(buf) <= 4 {
+		return
+	}
+	size := len(buf) - 4
+	prevPos := -1
+	var prevMask uint32
+
+	for i := 0; i < size; i++ {
+		if buf[i]&0xfe != 0xe8 {
+			continue
+		}
+
+		distance := i - prevPos
+		if distance > 3 {
+			prevMask = 0
+		} else {
+			prevMask = (prevMask << (distance - 1)) & 7
+			if prevMask != 0 {
+				b := buf[i+4-int(maskToBitNum[prevMask])]
+				if !maskToAllowed[prevMask] || testMSByte(b) {
+					prevPos = i
+					prevMask = prevMask<<1 | 1
+					continue
+				}
+			}
+		}
+		prevPos = i
+
+		if !testMSByte(buf[i+4]) {
+			prevMask = prevMask<<1 | 1
+			continue
+		}
+
+		src := binary.LittleEndian.Uint32(buf[i+1:])
+		var dest uint32
+		for {
+			dest = src - uint32(i+5)
+			if prevMask == 0 {
+				break
+			}
+			j := maskToBitNum[prevMask] * 8
+			if !testMSByte(byte(dest >> (24 - j))) {
+				break
+			}
+			src = dest ^ (1<<(32-j) - 1)
+		}
+		dest &= 0x01ffffff
+		dest |= 0 - dest&0x01000000
+		binary.LittleEndian.PutUint32(buf[i+1:], dest)
+		i += 4
+	}
+}
+
+// bcjARM64 converts the absolute addresses of arm64 BL and ADRP
+// instructions back to relative ones
+func bcjARM64(buf []byte) {
+	for i := 0; i+4 <= len(buf); i += 4 {
+		pc := uint32(i)
+		instr := binary.LittleEndian.Uint32(buf[i:])
+
+		switch {
+		case instr>>26 == 0x25: // BL
+			pc = 0 - pc>>2
+			instr = 0x94000000 | (instr+pc)&0x03ffffff
+			binary.LittleEndian.PutUint32(buf[i:], instr)
+
+		case instr&0x9f000000 == 0x90000000: // ADRP
+			src := (instr>>29)&3 | (instr>>3)&0x001ffffc
+			if (src+0x00020000)&0x001c0000 != 0 {
+				continue
+			}
+			pc = 0 - pc>>12
+			dest := src + pc
+			instr &= 0x9000001f
+			instr |= (dest & 3) << 29
+			instr |= (dest & 0x0003fffc) << 3
+			instr |= (0 - dest&0x00020000) & 0x00e00000
+			binary.LittleEndian.PutUint32(buf[i:], instr)
+		}
+	}
+}
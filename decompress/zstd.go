@@ -0,0 +1,855 @@
+package decompress
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// zstd format constants
+const (
+	zstdMagic           = 0xfd2fb528
+	zstdSkippableMagic  = 0x184d2a50 // Low four bits vary
+	zstdBlockRaw        = 0
+	zstdBlockRLE        = 1
+	zstdBlockCompressed = 2
+	zstdMaxBlockSize    = 128 << 10
+	zstdMaxHuffmanBits  = 11
+	zstdModePredefined  = 0
+	zstdModeRLE         = 1
+	zstdModeFSE         = 2
+	zstdModeRepeat      = 3
+)
+
+// Literal length and match length codes: baseline value and extra bits
+var (
+	zstdLiteralBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	zstdLiteralBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	zstdMatchBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	zstdMatchBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// Predefined FSE distributions of the sequence codes
+var (
+	zstdLiteralDefault = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	zstdMatchDefault = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	zstdOffsetDefault = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+// fseEntry is a state of an FSE decoding table
+type fseEntry struct {
+	symbol   uint8
+	nbBits   uint8
+	newState uint16
+}
+
+// fseTable is an FSE decoding table
+type fseTable struct {
+	log     uint8
+	entries []fseEntry
+}
+
+// huffmanEntry is an entry of a Huffman decoding table
+type huffmanEntry struct {
+	symbol uint8
+	nbBits uint8
+}
+
+// huffmanTable is a Huffman decoding table indexed by the next maxBits bits
+type huffmanTable struct {
+	maxBits uint8
+	entries []huffmanEntry
+}
+
+// zstdFrame holds the state kept across the blocks of a frame
+type zstdFrame struct {
+	out      []byte
+	rep      [3]uint32
+	huffman  *huffmanTable
+	literals *fseTable
+	offsets  *fseTable
+	matches  *fseTable
+}
+
+// decodeZstd decodes zstd frames until data ends or holds no further frame
+func decodeZstd(data []byte) ([]byte, error) {
+	var out []byte
+	pos := 0
+
+	for pos+4 <= len(data) {
+		magic := binary.LittleEndian.Uint32(data[pos:])
+		switch {
+		case magic&0xfffffff0 == zstdSkippableMagic:
+			if pos+8 > len(data) {
+				return nil, fmt.Errorf("truncated skippable frame")
+			}
+			pos += 8 + int(binary.LittleEndian.Uint32(data[pos+4:]))
+			continue
+		case magic != zstdMagic:
+			return out, nil
+		}
+
+		var err error
+		out, pos, err = decodeZstdFrame(data, pos+4, out)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// decodeZstdFrame decodes the frame whose header starts at pos, appending
+// to out, and returns the position after the frame
+func decodeZstdFrame(data []byte, pos int, out []byte) ([]byte, int, error) {
+	if pos >= len(data) {
+		return nil, 0, fmt.Errorf("truncated frame header")
+	}
+	descriptor := data[pos]
+	pos++
+
+	sizeFlag := descriptor >> 6
+	singleSegment := descriptor&0x20 != 0
+	hasChecksum := descriptor&0x04 != 0
+	dictIDSize := [4]int{0, 1, 2, 4}[descriptor&0x03]
+	if descriptor&0x08 != 0 {
+		return nil, 0, fmt.Errorf("reserved frame header bit set")
+	}
+
+	if !singleSegment {
+		pos++ // Window descriptor, the whole output is the window
+	}
+	if pos+dictIDSize > len(data) {
+		return nil, 0, fmt.Errorf("truncated frame header")
+	}
+	for i := 0; i < dictIDSize; i++ {
+		if data[pos+i] != 0 {
+			return nil, 0, fmt.Errorf("dictionaries are not supported")
+		}
+	}
+	pos += dictIDSize
+
+	sizeBytes := [4]int{0, 2, 4, 8}[sizeFlag]
+	if sizeFlag == 0 && singleSegment {
+		sizeBytes = 1
+	}
+	pos += sizeBytes
+
+	frame := &zstdFrame{out: out, rep: [3]uint32{1, 4, 8}}
+	frameStart := len(out)
+	for {
+		if pos+3 > len(data) {
+			return nil, 0, fmt.Errorf("truncated block header")
+		}
+		header := uint32(data[pos]) | uint32(data[pos+1])<<8 | uint32(data[pos+2])<<16
+		pos += 3
+
+		last := header&1 != 0
+		blockType := (header >> 1) & 3
+		size := int(header >> 3)
+
+		switch blockType {
+		case zstdBlockRaw:
+			if pos+size > len(data) {
+				return nil, 0, fmt.Errorf("truncated raw block")
+			}
+			frame.out = append(frame.out, data[pos:pos+size]...)
+			pos += size
+
+		case zstdBlockRLE:
+			if pos >= len(data) {
+				return nil, 0, fmt.Errorf("truncated RLE block")
+			}
+			for i := 0; i < size; i++ {
+				frame.out = append(frame.out, data[pos])
+			}
+			pos++
+
+		case zstdBlockCompressed:
+			if size > zstdMaxBlockSize || pos+size > len(data) {
+				return nil, 0, fmt.Errorf("invalid compressed block size %d", size)
+			}
+			if err := frame.decodeBlock(data[pos:pos+size], frameStart); err != nil {
+				return nil, 0, err
+			}
+			pos += size
+
+		default:
+			return nil, 0, fmt.Errorf("reserved block type")
+		}
+
+		if last {
+			break
+		}
+	}
+
+	// The content checksum is not verified
+	if hasChecksum {
+		pos += 4
+	}
+	return frame.out, pos, nil
+}
+
+// decodeBlock decodes a compressed block
+func (f *zstdFrame) decodeBlock(block []byte, frameStart int) error {
+	literals, pos, err := f.decodeLiterals(block)
+	if err != nil {
+		return fmt.Errorf("literals: %v", err)
+	}
+	if err := f.decodeSequences(block[pos:], literals, frameStart); err != nil {
+		return fmt.Errorf("sequences: %v", err)
+	}
+	return nil
+}
+
+// decodeLiterals decodes the literals section of a block and returns the
+// literals and the position after the section
+func (f *zstdFrame) decodeLiterals(block []byte) ([]byte, int, error) {
+	if len(block) < 1 {
+		return nil, 0, fmt.Errorf("truncated header")
+	}
+	literalType := block[0] & 3
+	sizeFormat := (block[0] >> 2) & 3
+
+	if literalType <= 1 {
+		// Raw or RLE literals
+		var size, headerSize int
+		switch sizeFormat {
+		case 0, 2:
+			size, headerSize = int(block[0]>>3), 1
+		case 1:
+			if len(block) < 2 {
+				return nil, 0, fmt.Errorf("truncated header")
+			}
+			size, headerSize = int(block[0]>>4)+int(block[1])<<4, 2
+		case 3:
+			if len(block) < 3 {
+				return nil, 0, fmt.Errorf("truncated header")
+			}
+			size, headerSize = int(block[0]>>4)+int(block[1])<<4+int(block[2])<<12, 3
+		}
+
+		if literalType == 0 {
+			if headerSize+size > len(block) {
+				return nil, 0, fmt.Errorf("truncated raw literals")
+			}
+			return block[headerSize : headerSize+size], headerSize + size, nil
+		}
+		if headerSize >= len(block) {
+			return nil, 0, fmt.Errorf("truncated RLE literals")
+		}
+		literals := make([]byte, size)
+		for i := range literals {
+			literals[i] = block[headerSize]
+		}
+		return literals, headerSize + 1, nil
+	}
+
+	// Huffman coded literals, type 3 reuses the previous table
+	var regenerated, compressed, headerSize int
+	streams := 4
+	switch sizeFormat {
+	case 0, 1:
+		if len(block) < 3 {
+			return nil, 0, fmt.Errorf("truncated header")
+		}
+		v := uint32(block[0]) | uint32(block[1])<<8 | uint32(block[2])<<16
+		regenerated, compressed, headerSize = int(v>>4)&0x3ff, int(v>>14)&0x3ff, 3
+		if sizeFormat == 0 {
+			streams = 1
+		}
+	case 2:
+		if len(block) < 4 {
+			return nil, 0, fmt.Errorf("truncated header")
+		}
+		v := binary.LittleEndian.Uint32(block)
+		regenerated, compressed, headerSize = int(v>>4)&0x3fff, int(v>>18)&0x3fff, 4
+	case 3:
+		if len(block) < 5 {
+			return nil, 0, fmt.Errorf("truncated header")
+		}
+		v := uint64(binary.LittleEndian.Uint32(block)) | uint64(block[4])<<32
+		regenerated, compressed, headerSize = int(v>>4)&0x3ffff, int(v>>22)&0x3ffff, 5
+	}
+	if headerSize+compressed > len(block) {
+		return nil, 0, fmt.Errorf("truncated Huffman literals")
+	}
+	src := block[headerSize : headerSize+compressed]
+
+	if literalType == 2 {
+		table, n, err := readHuffmanTable(src)
+		if err != nil {
+			return nil, 0, err
+		}
+		f.huffman = table
+		src = src[n:]
+	} else if f.huffman == nil {
+		return nil, 0, fmt.Errorf("treeless literals without a previous table")
+	}
+
+	literals := make([]byte, regenerated)
+	if streams == 1 {
+		if err := f.huffman.decodeStream(src, literals); err != nil {
+			return nil, 0, err
+		}
+		return literals, headerSize + compressed, nil
+	}
+
+	// Four streams, sized by a jump table
+	if len(src) < 6 {
+		return nil, 0, fmt.Errorf("truncated jump table")
+	}
+	sizes := [4]int{
+		int(binary.LittleEndian.Uint16(src)),
+		int(binary.LittleEndian.Uint16(src[2:])),
+		int(binary.LittleEndian.Uint16(src[4:])),
+	}
+	sizes[3] = len(src) - 6 - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] < 0 {
+		return nil, 0, fmt.Errorf("invalid jump table")
+	}
+	src = src[6:]
+
+	segment := (regenerated + 3) / 4
+	for i := 0; i < 4; i++ {
+		start := i * segment
+		end := min(start+segment, regenerated)
+		if i == 3 {
+			end = regenerated
+		}
+		if start > end {
+			return nil, 0, fmt.Errorf("invalid literal size")
+		}
+		if err := f.huffman.decodeStream(src[:sizes[i]], literals[start:end]); err != nil {
+			return nil, 0, err
+		}
+		src = src[sizes[i]:]
+	}
+	return literals, headerSize + compressed, nil
+}
+
+// readHuffmanTable reads a Huffman tree description and returns the table
+// and the bytes read
+func readHuffmanTable(src []byte) (*huffmanTable, int, error) {
+	if len(src) < 1 {
+		return nil, 0, fmt.Errorf("truncated Huffman tree")
+	}
+	header := int(src[0])
+	var weights []uint8
+	var n int
+
+	if header >= 128 {
+		// Weights stored directly, four bits each
+		count := header - 127
+		n = 1 + (count+1)/2
+		if n > len(src) {
+			return nil, 0, fmt.Errorf("truncated Huffman weights")
+		}
+		weights = make([]uint8, count)
+		for i := range weights {
+			b := src[1+i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 15
+			}
+		}
+	} else {
+		// FSE compressed weights
+		n = 1 + header
+		if n > len(src) {
+			return nil, 0, fmt.Errorf("truncated Huffman weights")
+		}
+		table, used, err := readFSETable(src[1:n], 255, 6)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Huffman weights: %v", err)
+		}
+		weights, err = decodeHuffmanWeights(src[1+used:n], table)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	table, err := buildHuffmanTable(weights)
+	if err != nil {
+		return nil, 0, err
+	}
+	return table, n, nil
+}
+
+// decodeHuffmanWeights decodes FSE compressed weights with two
+// interleaved states
+func decodeHuffmanWeights(src []byte, table *fseTable) ([]uint8, error) {
+	br, err := newBackwardReader(src)
+	if err != nil {
+		return nil, err
+	}
+
+	state1 := uint32(br.read(table.log))
+	state2 := uint32(br.read(table.log))
+	var weights []uint8
+	for len(weights) < 255 {
+		weights = append(weights, table.entries[state1].symbol)
+		state1 = table.update(state1, br)
+		if br.overflow() {
+			weights = append(weights, table.entries[state2].symbol)
+			break
+		}
+
+		weights = append(weights, table.entries[state2].symbol)
+		state2 = table.update(state2, br)
+		if br.overflow() {
+			weights = append(weights, table.entries[state1].symbol)
+			break
+		}
+	}
+	if len(weights) > 255 {
+		return nil, fmt.Errorf("too many Huffman weights")
+	}
+	return weights, nil
+}
+
+// buildHuffmanTable builds a decoding table from symbol weights. The
+// weight of the last symbol is implied.
+func buildHuffmanTable(weights []uint8) (*huffmanTable, error) {
+	var total uint32
+	for _, w := range weights {
+		if w > zstdMaxHuffmanBits {
+			return nil, fmt.Errorf("invalid Huffman weight %d", w)
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("empty Huffman tree")
+	}
+
+	maxBits := uint8(bits.Len32(total))
+	rest := uint32(1)<<maxBits - total
+	if rest&(rest-1) != 0 || maxBits > zstdMaxHuffmanBits {
+		return nil, fmt.Errorf("invalid Huffman tree")
+	}
+	weights = append(weights, uint8(bits.Len32(rest)))
+
+	// Symbols of the same weight take consecutive ranges, lowest weight
+	// first
+	var rankStart [zstdMaxHuffmanBits + 2]uint32
+	var rankCount [zstdMaxHuffmanBits + 2]uint32
+	for _, w := range weights {
+		rankCount[w]++
+	}
+	next := uint32(0)
+	for w := 1; w <= int(maxBits); w++ {
+		rankStart[w] = next
+		next += rankCount[w] << (w - 1)
+	}
+
+	table := &huffmanTable{maxBits: maxBits, entries: make([]huffmanEntry, 1<<maxBits)}
+	for symbol, w := range weights {
+		if w == 0 {
+			continue
+		}
+		length := uint32(1) << (w - 1)
+		entry := huffmanEntry{symbol: uint8(symbol), nbBits: maxBits + 1 - w}
+		for i := rankStart[w]; i < rankStart[w]+length; i++ {
+			table.entries[i] = entry
+		}
+		rankStart[w] += length
+	}
+	return table, nil
+}
+
+// decodeStream decodes one Huffman stream filling out
+func (t *huffmanTable) decodeStream(src []byte, out []byte) error {
+	br, err := newBackwardReader(src)
+	if err != nil {
+		return err
+	}
+	for i := range out {
+		entry := t.entries[br.peek(t.maxBits)]
+		br.skip(entry.nbBits)
+		out[i] = entry.symbol
+	}
+	if br.overflow() {
+		return fmt.Errorf("corrupt Huffman stream")
+	}
+	return nil
+}
+
+// readFSETable reads an FSE table description and returns the decoding
+// table and the bytes read
+func readFSETable(src []byte, maxSymbol int, maxLog uint8) (*fseTable, int, error) {
+	if len(src) < 1 {
+		return nil, 0, fmt.Errorf("truncated FSE table")
+	}
+	var bitPos uint
+	readBits := func(n uint) uint32 {
+		var v uint32
+		for i := uint(0); i < n; i++ {
+			byteIndex := (bitPos + i) / 8
+			if int(byteIndex) < len(src) {
+				v |= uint32(src[byteIndex]>>((bitPos+i)%8)&1) << i
+			}
+		}
+		return v
+	}
+
+	log := uint8(readBits(4)) + 5
+	bitPos = 4
+	if log > maxLog {
+		return nil, 0, fmt.Errorf("FSE accuracy %d too large", log)
+	}
+
+	remaining := int32(1)<<log + 1
+	threshold := int32(1) << log
+	nbBits := uint(log) + 1
+	var counts []int16
+
+	for remaining > 1 && len(counts) <= maxSymbol {
+		max := 2*threshold - 1 - remaining
+		var value int32
+		low := int32(readBits(nbBits - 1))
+		if low < max {
+			value = low
+			bitPos += nbBits - 1
+		} else {
+			value = int32(readBits(nbBits))
+			if value >= threshold {
+				value -= max
+			}
+			bitPos += nbBits
+		}
+
+		count := value - 1
+		if count < 0 {
+			remaining -= -count
+		} else {
+			remaining -= count
+		}
+		counts = append(counts, int16(count))
+
+		if count == 0 {
+			// Zero counts are followed by repeat flags
+			for {
+				repeat := readBits(2)
+				bitPos += 2
+				for i := uint32(0); i < repeat; i++ {
+					counts = append(counts, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+		}
+		for remaining < threshold && nbBits > 1 {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+
+	used := int((bitPos + 7) / 8)
+	if remaining != 1 || len(counts) > maxSymbol+1 || used > len(src) {
+		return nil, 0, fmt.Errorf("corrupt FSE table")
+	}
+	table, err := buildFSETable(counts, log)
+	if err != nil {
+		return nil, 0, err
+	}
+	return table, used, nil
+}
+
+// buildFSETable builds a decoding table from normalized counts, where -1
+// is a symbol of less than one table cell
+func buildFSETable(counts []int16, log uint8) (*fseTable, error) {
+	size := uint32(1) << log
+	table := &fseTable{log: log, entries: make([]fseEntry, size)}
+	next := make([]uint32, len(counts))
+
+	high := size - 1
+	for s, c := range counts {
+		if c == -1 {
+			table.entries[high].symbol = uint8(s)
+			high--
+			next[s] = 1
+		} else if c > 0 {
+			next[s] = uint32(c)
+		}
+	}
+
+	step := size>>1 + size>>3 + 3
+	mask := size - 1
+	pos := uint32(0)
+	for s, c := range counts {
+		for i := int16(0); i < c; i++ {
+			table.entries[pos].symbol = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, fmt.Errorf("corrupt FSE distribution")
+	}
+
+	for i := range table.entries {
+		e := &table.entries[i]
+		state := next[e.symbol]
+		next[e.symbol]++
+		e.nbBits = log - uint8(bits.Len32(state)-1)
+		e.newState = uint16(state<<e.nbBits - size)
+	}
+	return table, nil
+}
+
+// rleTable returns a table always decoding symbol
+func rleTable(symbol uint8) *fseTable {
+	return &fseTable{log: 0, entries: []fseEntry{{symbol: symbol}}}
+}
+
+// update moves to the next state, reading its bits
+func (t *fseTable) update(state uint32, br *backwardReader) uint32 {
+	e := t.entries[state]
+	return uint32(e.newState) + uint32(br.read(e.nbBits))
+}
+
+// decodeSequences decodes the sequences section and executes the
+// sequences, appending to the frame output
+func (f *zstdFrame) decodeSequences(src []byte, literals []byte, frameStart int) error {
+	if len(src) < 1 {
+		return fmt.Errorf("truncated header")
+	}
+	count := int(src[0])
+	pos := 1
+	switch {
+	case count == 0:
+		f.out = append(f.out, literals...)
+		return nil
+	case count == 255:
+		if len(src) < 3 {
+			return fmt.Errorf("truncated header")
+		}
+		count = int(src[1]) + int(src[2])<<8 + 0x7f00
+		pos = 3
+	case count >= 128:
+		if len(src) < 2 {
+			return fmt.Errorf("truncated header")
+		}
+		count = (count-128)<<8 + int(src[1])
+		pos = 2
+	}
+
+	if pos >= len(src) {
+		return fmt.Errorf("truncated header")
+	}
+	modes := src[pos]
+	pos++
+	if modes&3 != 0 {
+		return fmt.Errorf("reserved compression mode bits set")
+	}
+
+	var err error
+	var n int
+	f.literals, n, err = selectFSETable(src[pos:], modes>>6, f.literals, zstdLiteralDefault, 6, 35, 9)
+	if err != nil {
+		return fmt.Errorf("literal lengths: %v", err)
+	}
+	pos += n
+	f.offsets, n, err = selectFSETable(src[pos:], (modes>>4)&3, f.offsets, zstdOffsetDefault, 5, 31, 8)
+	if err != nil {
+		return fmt.Errorf("offsets: %v", err)
+	}
+	pos += n
+	f.matches, n, err = selectFSETable(src[pos:], (modes>>2)&3, f.matches, zstdMatchDefault, 6, 52, 9)
+	if err != nil {
+		return fmt.Errorf("match lengths: %v", err)
+	}
+	pos += n
+
+	br, err := newBackwardReader(src[pos:])
+	if err != nil {
+		return err
+	}
+	llState := uint32(br.read(f.literals.log))
+	ofState := uint32(br.read(f.offsets.log))
+	mlState := uint32(br.read(f.matches.log))
+
+	for i := 0; i < count; i++ {
+		llCode := f.literals.entries[llState].symbol
+		ofCode := f.offsets.entries[ofState].symbol
+		mlCode := f.matches.entries[mlState].symbol
+		if llCode > 35 || mlCode > 52 || ofCode > 31 {
+			return fmt.Errorf("invalid sequence code")
+		}
+
+		offsetValue := uint32(1)<<ofCode + uint32(br.read(ofCode))
+		matchLen := zstdMatchBase[mlCode] + uint32(br.read(zstdMatchBits[mlCode]))
+		literalLen := zstdLiteralBase[llCode] + uint32(br.read(zstdLiteralBits[llCode]))
+
+		if i < count-1 {
+			llState = f.literals.update(llState, br)
+			mlState = f.matches.update(mlState, br)
+			ofState = f.offsets.update(ofState, br)
+		}
+		if br.overflow() {
+			return fmt.Errorf("corrupt sequence bitstream")
+		}
+
+		if int(literalLen) > len(literals) {
+			return fmt.Errorf("literal length beyond the literals")
+		}
+		f.out = append(f.out, literals[:literalLen]...)
+		literals = literals[literalLen:]
+
+		offset := f.resolveOffset(offsetValue, literalLen)
+		if offset == 0 || int(offset) > len(f.out)-frameStart {
+			return fmt.Errorf("invalid match offset %d", offset)
+		}
+		f.out = copyMatch(f.out, int(offset), int(matchLen))
+	}
+
+	f.out = append(f.out, literals...)
+	return nil
+}
+
+// resolveOffset turns an offset value into a match offset, applying and
+// updating the repeated offsets
+func (f *zstdFrame) resolveOffset(value, literalLen uint32) uint32 {
+	if value > 3 {
+		offset := value - 3
+		f.rep = [3]uint32{offset, f.rep[0], f.rep[1]}
+		return offset
+	}
+
+	index := value
+	if literalLen == 0 {
+		index++
+	}
+	switch index {
+	case 1:
+		return f.rep[0]
+	case 2:
+		f.rep = [3]uint32{f.rep[1], f.rep[0], f.rep[2]}
+	case 3:
+		f.rep = [3]uint32{f.rep[2], f.rep[0], f.rep[1]}
+	default:
+		f.rep = [3]uint32{f.rep[0] - 1, f.rep[0], f.rep[1]}
+	}
+	return f.rep[0]
+}
+
+// selectFSETable returns the table for a sequence code and the bytes read
+// for its description
+func selectFSETable(src []byte, mode uint8, previous *fseTable, defaults []int16, defaultLog uint8, maxSymbol int, maxLog uint8) (*fseTable, int, error) {
+	switch mode {
+	case zstdModePredefined:
+		table, err := buildFSETable(defaults, defaultLog)
+		return table, 0, err
+	case zstdModeRLE:
+		if len(src) < 1 {
+			return nil, 0, fmt.Errorf("truncated RLE symbol")
+		}
+		return rleTable(src[0]), 1, nil
+	case zstdModeFSE:
+		return readFSETable(src, maxSymbol, maxLog)
+	default:
+		if previous == nil {
+			return nil, 0, fmt.Errorf("repeated table without a previous one")
+		}
+		return previous, 0, nil
+	}
+}
+
+// backwardReader reads a bitstream from its end, as FSE and Huffman
+// streams are written. Reading past the start yields zero bits and marks
+// the reader overflowed.
+type backwardReader struct {
+	data   []byte
+	bitPos int // Bits left to read
+}
+
+// newBackwardReader starts reading below the end marker, the highest set
+// bit of the last byte
+func newBackwardReader(data []byte) (*backwardReader, error) {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return nil, fmt.Errorf("bitstream without end marker")
+	}
+	last := data[len(data)-1]
+	return &backwardReader{data: data, bitPos: len(data)*8 - 8 + bits.Len8(last) - 1}, nil
+}
+
+// peek returns the next n bits without consuming them
+func (br *backwardReader) peek(n uint8) uint64 {
+	if n == 0 || br.bitPos <= 0 {
+		return 0
+	}
+	low := br.bitPos - int(n)
+	if low >= 0 {
+		return br.extract(low, n)
+	}
+	// Missing bits below the start are zero
+	return br.extract(0, uint8(br.bitPos)) << uint(-low)
+}
+
+// skip consumes n bits
+func (br *backwardReader) skip(n uint8) {
+	br.bitPos -= int(n)
+}
+
+// read consumes and returns the next n bits
+func (br *backwardReader) read(n uint8) uint64 {
+	v := br.peek(n)
+	br.skip(n)
+	return v
+}
+
+// overflow reports whether more bits were read than the stream holds
+func (br *backwardReader) overflow() bool {
+	return br.bitPos < 0
+}
+
+// extract returns n bits starting at bit low, counted from the start of
+// the data
+func (br *backwardReader) extract(low int, n uint8) uint64 {
+	if n == 0 || low < 0 {
+		return 0
+	}
+	first := low / 8
+	var buf [8]byte
+	copy(buf[:], br.data[first:])
+	v := binary.LittleEndian.Uint64(buf[:]) >> uint(low%8)
+	if n < 64 {
+		v &= 1<<n - 1
+	}
+	return v
+}
@@ -0,0 +1,359 @@
+package discover
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/timoxa0/kxmenu/entry"
+)
+
+// Partition is a block device with a recognized filesystem
+type Partition struct {
+	Name       string // Kernel name, e.g. mmcblk0p1
+	PartName   string // GPT partition name, e.g. boot_a
+	Device     string // Device node path
+	Filesystem *Filesystem
+}
+
+// String describes the partition for menus, e.g. "sda1 (ext4, boot)"
+func (p Partition) String() string {
+	desc := p.Filesystem.Type
+	if p.Filesystem.Label != "" {
+		desc += ", " + p.Filesystem.Label
+	} else if p.PartName != "" {
+		desc += ", " + p.PartName
+	}
+	return fmt.Sprintf("%s (%s)", p.Name, desc)
+}
+
+// DeviceEntries holds the boot entries found on one partition
+type DeviceEntries struct {
+	Partition Partition
+	Mount     string // Mount point, empty for raw partitions
+	Entries   []*entry.BootEntry
+}
+
+// ignoredDevices are block device name prefixes never holding boot files
+var ignoredDevices = []string{"loop", "ram", "zram", "nbd"}
+
+// ListPartitions enumerates block devices in /sys/class/block and returns
+// those with a recognized filesystem
+func ListPartitions() ([]Partition, error) {
+	names, err := os.ReadDir("/sys/class/block")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list block devices: %v", err)
+	}
+
+	var partitions []Partition
+	for _, name := range names {
+		if isIgnoredDevice(name.Name()) || deviceSize(name.Name()) == 0 {
+			continue
+		}
+
+		device := filepath.Join("/dev", name.Name())
+		fs, err := Probe(device)
+		if err != nil {
+			continue // Unknown filesystem or unreadable device
+		}
+
+		partitions = append(partitions, Partition{
+			Name:       name.Name(),
+			PartName:   partitionName(name.Name()),
+			Device:     device,
+			Filesystem: fs,
+		})
+	}
+
+	return partitions, nil
+}
+
+// isIgnoredDevice checks if a block device name matches ignoredDevices
+func isIgnoredDevice(name string) bool {
+	for _, prefix := range ignoredDevices {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// partitionName returns the GPT partition name from the device's uevent
+func partitionName(name string) string {
+	data, err := os.ReadFile(filepath.Join("/sys/class/block", name, "uevent"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "PARTNAME="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// deviceSize returns the size of a block device in 512-byte sectors
+func deviceSize(name string) int64 {
+	data, err := os.ReadFile(filepath.Join("/sys/class/block", name, "size"))
+	if err != nil {
+		return 0
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// mountFlags are the flags of every mount. Partitions are mounted
+// read-only on top of them unless made writable.
+const mountFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC
+
+// Mounter mounts partitions read-only below a private directory
+type Mounter struct {
+	dir    string
+	mounts []string
+}
+
+// NewMounter creates the private mount directory
+func NewMounter() (*Mounter, error) {
+	dir, err := os.MkdirTemp("", "kxmenu-mounts-")
+	if err != nil {
+		return nil, err
+	}
+	return &Mounter{dir: dir}, nil
+}
+
+// Mount mounts a partition read-only and returns the mount point
+func (m *Mounter) Mount(p Partition) (string, error) {
+	target := filepath.Join(m.dir, p.Name)
+	if err := os.Mkdir(target, 0700); err != nil {
+		return "", err
+	}
+
+	// The ext4 driver also mounts ext2 and ext3
+	types := []string{p.Filesystem.Type}
+	if p.Filesystem.Type == "ext2" || p.Filesystem.Type == "ext3" {
+		types = append(types, "ext4")
+	}
+
+	flags := uintptr(syscall.MS_RDONLY | mountFlags)
+	var err error
+	for _, fsType := range types {
+		if err = syscall.Mount(p.Device, target, fsType, flags, ""); err == nil {
+			m.mounts = append(m.mounts, target)
+			return target, nil
+		}
+	}
+
+	os.Remove(target)
+	return "", fmt.Errorf("failed to mount %s: %v", p.Device, err)
+}
+
+// MakeWritable remounts a mount point created by Mount read-write
+func (m *Mounter) MakeWritable(target string) error {
+	if err := syscall.Mount("", target, "", syscall.MS_REMOUNT|mountFlags, ""); err != nil {
+		return fmt.Errorf("failed to remount %s read-write: %v", target, err)
+	}
+	return nil
+}
+
+// Unmount unmounts a mount point created by Mount
+func (m *Mounter) Unmount(target string) error {
+	if err := syscall.Unmount(target, 0); err != nil {
+		return fmt.Errorf("failed to unmount %s: %v", target, err)
+	}
+	m.forget(target)
+	return os.Remove(target)
+}
+
+// UnmountAll unmounts every partition and removes the mount directory
+func (m *Mounter) UnmountAll() error {
+	var firstErr error
+	for len(m.mounts) > 0 {
+		target := m.mounts[len(m.mounts)-1]
+		if err := m.Unmount(target); err != nil {
+			// Detach so the kexec'd kernel does not inherit a busy mount,
+			// flushing renamed boot entries first
+			syscall.Sync()
+			syscall.Unmount(target, syscall.MNT_DETACH)
+			m.forget(target)
+			os.Remove(target)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if err := os.Remove(m.dir); err != nil && !os.IsNotExist(err) && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// forget removes a mount point from the list of active mounts
+func (m *Mounter) forget(target string) {
+	for i, mount := range m.mounts {
+		if mount == target {
+			m.mounts = append(m.mounts[:i], m.mounts[i+1:]...)
+			return
+		}
+	}
+}
+
+// partitionLimits keep the search of a partition, which may hold a whole
+// root filesystem, to where boot entries live. boot/efi/EFI/Linux is the
+// deepest such directory.
+var partitionLimits = entry.WalkLimits{
+	MaxDepth: 4,
+	SkipDirs: map[string]bool{
+		"bin": true, "dev": true, "etc": true, "home": true, "lib": true,
+		"lib64": true, "proc": true, "root": true, "run": true, "sbin": true,
+		"srv": true, "sys": true, "tmp": true, "usr": true, "var": true,
+	},
+}
+
+// Discover mounts every partition with a recognized filesystem and looks
+// for boot entries on it. Partitions without entries are unmounted again,
+// the rest stay mounted until the Mounter is cleaned up, writable if they
+// hold entries with a boot counter. Each entry's BootRoot and Device point
+// at its partition.
+func Discover(sources []entry.EntrySource) ([]DeviceEntries, *Mounter, error) {
+	partitions, err := ListPartitions()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mounter, err := NewMounter()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var results []DeviceEntries
+	for _, p := range partitions {
+		// Raw boot partitions are entries themselves
+		if p.Filesystem.Type == AndroidBootType || p.Filesystem.Type == AndroidVendorBootType {
+			if !hasSource(sources, "android") {
+				continue
+			}
+			// init_boot and vendor_boot are loaded with their boot partition
+			if p.Filesystem.Type == AndroidVendorBootType || strings.HasPrefix(p.PartName, "init_boot") {
+				continue
+			}
+			e, err := entry.ParseAndroidBoot(androidImages(p, partitions), "/")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				continue
+			}
+			if p.PartName != "" {
+				e.ID = p.PartName
+				e.Title = "Android " + p.PartName
+			}
+			e.Source = "android"
+			e.Device = p.Device
+			e.BootRoot = "/"
+			results = append(results, DeviceEntries{Partition: p, Entries: []*entry.BootEntry{e}})
+			continue
+		}
+
+		target, err := mounter.Mount(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			continue
+		}
+
+		entries, err := entry.FindEntriesWithin(target, sources, partitionLimits)
+		if err != nil || len(entries) == 0 {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+			mounter.Unmount(target)
+			continue
+		}
+
+		// Boot counting renames entry files before the kexec, which needs
+		// a writable partition
+		if hasBootCounter(entries) {
+			if err := mounter.MakeWritable(target); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+
+		for _, e := range entries {
+			e.BootRoot = target
+			e.Device = p.Device
+		}
+		results = append(results, DeviceEntries{Partition: p, Mount: target, Entries: entries})
+	}
+
+	return results, mounter, nil
+}
+
+// BootPartition returns the mount point of the discovered partition a
+// booted system mounts as /boot: the first holding loader/entries, as an
+// XBOOTLDR partition or ESP does, or else the first ESP. It returns "" if
+// there is none.
+func BootPartition(results []DeviceEntries) string {
+	for _, result := range results {
+		if result.Mount != "" && isDir(filepath.Join(result.Mount, "loader", "entries")) {
+			return result.Mount
+		}
+	}
+	for _, result := range results {
+		if result.Mount != "" && result.Partition.Filesystem.Type == "vfat" && isDir(filepath.Join(result.Mount, "EFI")) {
+			return result.Mount
+		}
+	}
+	return ""
+}
+
+// isDir checks if path is a directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// androidImages finds the init_boot and vendor_boot partitions of the same
+// slot as a boot partition, e.g. init_boot_a and vendor_boot_a for boot_a
+func androidImages(boot Partition, partitions []Partition) entry.AndroidImages {
+	images := entry.AndroidImages{Boot: boot.Device}
+
+	suffix, ok := strings.CutPrefix(boot.PartName, "boot")
+	if !ok {
+		return images
+	}
+
+	for _, p := range partitions {
+		switch {
+		case p.PartName == "init_boot"+suffix && p.Filesystem.Type == AndroidBootType:
+			images.InitBoot = p.Device
+		case p.PartName == "vendor_boot"+suffix && p.Filesystem.Type == AndroidVendorBootType:
+			images.VendorBoot = p.Device
+		}
+	}
+
+	return images
+}
+
+// hasBootCounter checks if any entry has a boot counter
+func hasBootCounter(entries []*entry.BootEntry) bool {
+	for _, e := range entries {
+		if e.BootCounting {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSource checks if a source with the given name is in sources
+func hasSource(sources []entry.EntrySource, name string) bool {
+	for _, source := range sources {
+		if source.Name() == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package discover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// probeSize covers every superblock location checked by probeFilesystem,
+// the furthest being btrfs at 64 KiB
+const probeSize = 0x10000 + 0x1000
+
+// Filesystem types of raw Android boot partitions
+const (
+	AndroidBootType       = "android-boot"
+	AndroidVendorBootType = "android-vendor-boot"
+)
+
+// Filesystem describes a filesystem identified by its superblock
+type Filesystem struct {
+	Type  string // Type name as passed to mount(2)
+	UUID  string
+	Label string
+}
+
+// Probe identifies the filesystem on a block device
+func Probe(device string) (*Filesystem, error) {
+	file, err := os.Open(device)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, probeSize)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	fs := probeFilesystem(buf[:n])
+	if fs == nil {
+		return nil, fmt.Errorf("%s: unknown filesystem", device)
+	}
+	return fs, nil
+}
+
+// probeFilesystem checks buf, the start of a device, for known superblocks
+func probeFilesystem(buf []byte) *Filesystem {
+	le16 := func(off int) uint16 { return binary.LittleEndian.Uint16(buf[off:]) }
+	le32 := func(off int) uint32 { return binary.LittleEndian.Uint32(buf[off:]) }
+	has := func(off int, magic string) bool {
+		return len(buf) >= off+len(magic) && string(buf[off:off+len(magic)]) == magic
+	}
+
+	// Raw Android boot partition, booted without mounting
+	if has(0, "ANDROID!") {
+		return &Filesystem{Type: AndroidBootType}
+	}
+	if has(0, "VNDRBOOT") {
+		return &Filesystem{Type: AndroidVendorBootType}
+	}
+
+	// ext2/3/4: magic 0xEF53 in the superblock at 1024
+	if len(buf) >= 2048 && le16(1024+0x38) == 0xEF53 {
+		fsType := "ext2"
+		if le32(1024+0x5C)&0x4 != 0 { // has_journal
+			fsType = "ext3"
+		}
+		if le32(1024+0x60)&(0x40|0x80|0x200) != 0 { // extents, 64bit, flex_bg
+			fsType = "ext4"
+		}
+		return &Filesystem{
+			Type:  fsType,
+			UUID:  formatUUID(buf[1024+0x68 : 1024+0x78]),
+			Label: cString(buf[1024+0x78 : 1024+0x88]),
+		}
+	}
+
+	// btrfs: magic at 64 KiB + 0x40
+	if has(0x10040, "_BHRfS_M") {
+		return &Filesystem{
+			Type:  "btrfs",
+			UUID:  formatUUID(buf[0x10020:0x10030]),
+			Label: cString(buf[0x1012B : 0x1012B+256]),
+		}
+	}
+
+	// XFS: magic at the start of the device
+	if has(0, "XFSB") {
+		return &Filesystem{
+			Type:  "xfs",
+			UUID:  formatUUID(buf[32:48]),
+			Label: cString(buf[108:120]),
+		}
+	}
+
+	// F2FS and EROFS: magic in the superblock at 1024
+	if len(buf) >= 2048 && le32(1024) == 0xF2F52010 {
+		return &Filesystem{Type: "f2fs"}
+	}
+	if len(buf) >= 2048 && le32(1024) == 0xE0F5E1E2 {
+		return &Filesystem{Type: "erofs"}
+	}
+
+	// ISO 9660: primary volume descriptor at 32 KiB
+	if has(0x8001, "CD001") {
+		return &Filesystem{
+			Type:  "iso9660",
+			Label: strings.TrimSpace(string(buf[0x8028 : 0x8028+32])),
+		}
+	}
+
+	// FAT: boot sector signature plus the filesystem type string
+	if len(buf) >= 512 && buf[510] == 0x55 && buf[511] == 0xAA {
+		switch {
+		case has(82, "FAT32"):
+			return &Filesystem{
+				Type:  "vfat",
+				UUID:  formatVolumeID(le32(67)),
+				Label: fatLabel(buf[71:82]),
+			}
+		case has(54, "FAT1"):
+			return &Filesystem{
+				Type:  "vfat",
+				UUID:  formatVolumeID(le32(39)),
+				Label: fatLabel(buf[43:54]),
+			}
+		}
+	}
+
+	return nil
+}
+
+// formatUUID formats 16 bytes as a standard UUID string
+func formatUUID(b []byte) string {
+	if bytes.Equal(b, make([]byte, 16)) {
+		return ""
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// formatVolumeID formats a FAT volume serial number as XXXX-XXXX
+func formatVolumeID(id uint32) string {
+	return fmt.Sprintf("%04X-%04X", id>>16, id&0xFFFF)
+}
+
+// fatLabel returns a FAT volume label, empty for the "NO NAME" placeholder
+func fatLabel(b []byte) string {
+	label := strings.TrimSpace(string(b))
+	if label == "NO NAME" {
+		return ""
+	}
+	return label
+}
+
+// cString returns the NUL-terminated string at the start of b
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,296 @@
+package entry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	androidBootMagic = "ANDROID!"
+
+	// Header versions 3 and up use a fixed page size
+	androidFixedPageSize = 4096
+)
+
+// ImageSection locates a payload inside an image file
+type ImageSection struct {
+	Offset int64
+	Size   int64
+}
+
+// AndroidBootImage is a parsed Android boot.img header, versions 0 to 4
+type AndroidBootImage struct {
+	Path          string
+	HeaderVersion uint32
+	PageSize      uint32
+	Name          string
+	Cmdline       string // cmdline and extra_cmdline joined
+	OSVersion     string // e.g. 13.0.0, empty if unset
+	PatchLevel    string // e.g. 2023-05, empty if unset
+	Kernel        ImageSection
+	Ramdisk       ImageSection
+	Second        ImageSection
+	RecoveryDTBO  ImageSection
+	DTB           ImageSection
+}
+
+// isAndroidBootFile checks if a file is an .img starting with the boot image magic
+func isAndroidBootFile(filePath string) bool {
+	if !strings.HasSuffix(filePath, ".img") {
+		return false
+	}
+	return hasMagic(filePath, androidBootMagic)
+}
+
+// hasMagic checks if a file starts with magic
+func hasMagic(filePath, magic string) bool {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(file, buf); err != nil {
+		return false
+	}
+	return string(buf) == magic
+}
+
+// OpenAndroidBootImage reads and validates the header of an Android boot
+// image file or partition
+func OpenAndroidBootImage(imagePath string) (*AndroidBootImage, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hdr := make([]byte, 1660) // Size of the version 2 header, the largest
+	n, err := io.ReadFull(file, hdr)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	hdr = hdr[:n]
+
+	if len(hdr) < 48 || string(hdr[:8]) != androidBootMagic {
+		return nil, fmt.Errorf("%s: not an Android boot image", imagePath)
+	}
+
+	u32 := func(off int) uint32 { return binary.LittleEndian.Uint32(hdr[off:]) }
+	img := &AndroidBootImage{Path: imagePath}
+
+	// header_version is at offset 40 in every version, versions 3 and 4
+	// changed the layout around it
+	if v := u32(40); v >= 3 {
+		if len(hdr) < 1580 {
+			return nil, fmt.Errorf("%s: truncated boot image header", imagePath)
+		}
+		img.HeaderVersion = v
+		img.PageSize = androidFixedPageSize
+		img.setOSVersion(u32(16))
+		img.Cmdline = cString(hdr[44 : 44+1536])
+
+		img.Kernel = ImageSection{Size: int64(u32(8))}
+		img.Ramdisk = ImageSection{Size: int64(u32(12))}
+		img.layout(&img.Kernel, &img.Ramdisk)
+		return img, nil
+	}
+
+	if len(hdr) < 1632 {
+		return nil, fmt.Errorf("%s: truncated boot image header", imagePath)
+	}
+	img.HeaderVersion = u32(40)
+	img.PageSize = u32(36)
+	if img.PageSize == 0 {
+		return nil, fmt.Errorf("%s: invalid page size", imagePath)
+	}
+	img.setOSVersion(u32(44))
+	img.Name = cString(hdr[48:64])
+	img.Cmdline = cString(hdr[64:576]) + cString(hdr[608:1632])
+
+	img.Kernel = ImageSection{Size: int64(u32(8))}
+	img.Ramdisk = ImageSection{Size: int64(u32(16))}
+	img.Second = ImageSection{Size: int64(u32(24))}
+	sections := []*ImageSection{&img.Kernel, &img.Ramdisk, &img.Second}
+
+	if img.HeaderVersion >= 1 && len(hdr) >= 1648 {
+		img.RecoveryDTBO = ImageSection{Size: int64(u32(1632))}
+		sections = append(sections, &img.RecoveryDTBO)
+	}
+	if img.HeaderVersion >= 2 && len(hdr) >= 1660 {
+		img.DTB = ImageSection{Size: int64(u32(1648))}
+		sections = append(sections, &img.DTB)
+	}
+
+	img.layout(sections...)
+	return img, nil
+}
+
+// layout assigns offsets to sections stored one after another, each
+// starting on a page boundary after the header page
+func (img *AndroidBootImage) layout(sections ...*ImageSection) {
+	offset := int64(img.PageSize)
+	for _, s := range sections {
+		s.Offset = offset
+		offset += alignUp(s.Size, int64(img.PageSize))
+	}
+}
+
+// setOSVersion decodes the packed os_version header field
+func (img *AndroidBootImage) setOSVersion(v uint32) {
+	if v == 0 {
+		return
+	}
+
+	version := v >> 11
+	a, b, c := version>>14, (version>>7)&0x7F, version&0x7F
+	if version != 0 {
+		img.OSVersion = fmt.Sprintf("%d.%d.%d", a, b, c)
+	}
+
+	level := v & 0x7FF
+	if level != 0 {
+		img.PatchLevel = fmt.Sprintf("%04d-%02d", 2000+(level>>4), level&0xF)
+	}
+}
+
+// ReadSection reads a payload of the image. Empty sections return nil.
+func (img *AndroidBootImage) ReadSection(s ImageSection) ([]byte, error) {
+	return readImageSection(img.Path, s)
+}
+
+// readImageSection reads a section of an image file
+func readImageSection(imagePath string, s ImageSection) ([]byte, error) {
+	if s.Size == 0 {
+		return nil, nil
+	}
+
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := make([]byte, s.Size)
+	if _, err := file.ReadAt(data, s.Offset); err != nil {
+		return nil, fmt.Errorf("%s: failed to read %d bytes at %d: %v", imagePath, s.Size, s.Offset, err)
+	}
+	return data, nil
+}
+
+// ParseAndroidBootImage builds a boot entry from an Android boot image file
+// and the init_boot and vendor_boot images found next to it
+func ParseAndroidBootImage(imagePath, baseDir string) (*BootEntry, error) {
+	return ParseAndroidBoot(FindAndroidCompanions(imagePath), baseDir)
+}
+
+// ParseAndroidBoot builds a boot entry from a set of Android boot images.
+// The boot and vendor_boot command lines are merged. Image paths are made
+// relative to baseDir, the root of the boot partition.
+func ParseAndroidBoot(images AndroidImages, baseDir string) (*BootEntry, error) {
+	img, err := OpenAndroidBootImage(images.Boot)
+	if err != nil {
+		return nil, err
+	}
+
+	relPath := func(p string) (string, error) {
+		if p == "" {
+			return "", nil
+		}
+		rel, err := filepath.Rel(baseDir, p)
+		if err != nil {
+			return "", err
+		}
+		return path.Join("/", filepath.ToSlash(rel)), nil
+	}
+
+	e := &BootEntry{
+		ID:       filepath.Base(images.Boot),
+		Title:    img.Name,
+		Version:  strings.TrimSpace(img.OSVersion + " " + img.PatchLevel),
+		Options:  strings.TrimSpace(img.Cmdline),
+		FilePath: images.Boot,
+	}
+	if e.Title == "" {
+		e.Title = "Android " + filepath.Base(images.Boot)
+	}
+
+	if e.AndroidBoot, err = relPath(images.Boot); err != nil {
+		return nil, err
+	}
+	if e.AndroidInitBoot, err = relPath(images.InitBoot); err != nil {
+		return nil, err
+	}
+	if e.AndroidVendorBoot, err = relPath(images.VendorBoot); err != nil {
+		return nil, err
+	}
+
+	if images.VendorBoot != "" {
+		vendor, err := OpenVendorBootImage(images.VendorBoot)
+		if err != nil {
+			return nil, err
+		}
+
+		// The vendor command line follows the generic one
+		e.Options = strings.TrimSpace(e.Options + " " + strings.TrimSpace(vendor.Cmdline))
+
+		// The kernel only parses bootconfig appended to the initrd when asked to
+		if vendor.Bootconfig.Size > 0 && !containsWord(e.Options, "bootconfig") {
+			e.Options = strings.TrimSpace(e.Options + " bootconfig")
+		}
+	}
+
+	return e, nil
+}
+
+// containsWord checks if a space-separated list contains word
+func containsWord(list, word string) bool {
+	for _, w := range strings.Fields(list) {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// alignUp rounds n up to a multiple of align
+func alignUp(n, align int64) int64 {
+	return (n + align - 1) / align * align
+}
+
+// androidSource finds Android boot images stored as .img files
+type androidSource struct{}
+
+func (androidSource) Name() string {
+	return "android"
+}
+
+func (androidSource) Discover(dir string, limits WalkLimits) ([]*BootEntry, error) {
+	return walkEntryFiles(dir, limits,
+		func(path string, info os.FileInfo) bool {
+			// init_boot images are loaded with their boot image
+			return isAndroidBootFile(path) && !strings.HasPrefix(info.Name(), "init_boot")
+		},
+		func(path string) ([]*BootEntry, error) {
+			e, err := ParseAndroidBootImage(path, dir)
+			if err != nil {
+				return nil, err
+			}
+			return []*BootEntry{e}, nil
+		})
+}
+
+// cString returns the NUL-terminated string at the start of b
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,97 @@
+package entry
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseBootCounter splits the boot counter of the Boot Loader Specification
+// off a file name: "foo+3-1.conf" gives "foo.conf" with 3 tries left and 1
+// done. The done count is optional. ok is false for names without a counter.
+func parseBootCounter(name string) (id string, left, done int, ok bool) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	i := strings.LastIndexByte(stem, '+')
+	if i < 0 {
+		return name, 0, 0, false
+	}
+
+	leftStr, doneStr, hasDone := strings.Cut(stem[i+1:], "-")
+	left, err := parseCount(leftStr)
+	if err != nil {
+		return name, 0, 0, false
+	}
+	if hasDone {
+		if done, err = parseCount(doneStr); err != nil {
+			return name, 0, 0, false
+		}
+	}
+
+	return stem[:i] + ext, left, done, true
+}
+
+// parseCount parses a non-negative decimal counter
+func parseCount(s string) (int, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid counter %q", s)
+	}
+	return strconv.Atoi(s)
+}
+
+// setBootCounter sets the ID and boot counter of an entry from its file name
+func (e *BootEntry) setBootCounter() {
+	id, left, done, ok := parseBootCounter(filepath.Base(e.FilePath))
+	e.ID = id
+	e.BootCounting = ok
+	e.TriesLeft = left
+	e.TriesDone = done
+}
+
+// IsBad reports whether the entry ran out of boot attempts. Entries without
+// a boot counter are good.
+func (e *BootEntry) IsBad() bool {
+	return e.BootCounting && e.TriesLeft == 0
+}
+
+// CountBootAttempt records a boot attempt by renaming the entry file with
+// one try less left and one more done, "foo+3-0.conf" to "foo+2-1.conf".
+// The booted system renames the file to "foo.conf" once it is found good.
+// Entries without a counter or without tries left are not touched.
+func (e *BootEntry) CountBootAttempt() error {
+	if !e.BootCounting || e.TriesLeft == 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(e.FilePath)
+	ext := filepath.Ext(e.ID)
+	name := fmt.Sprintf("%s+%d-%d%s", strings.TrimSuffix(e.ID, ext), e.TriesLeft-1, e.TriesDone+1, ext)
+	newPath := filepath.Join(dir, name)
+
+	if err := os.Rename(e.FilePath, newPath); err != nil {
+		return fmt.Errorf("failed to count boot attempt: %v", err)
+	}
+	syncDir(dir)
+
+	if e.Efi != "" {
+		e.Efi = path.Join(path.Dir(e.Efi), name)
+	}
+	e.FilePath = newPath
+	e.TriesLeft--
+	e.TriesDone++
+	return nil
+}
+
+// syncDir flushes a directory so a rename survives the reboot into the new kernel
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.Sync()
+}
@@ -0,0 +1,228 @@
+package entry
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// CmdlinePolicy is a list of rules rewriting the kernel command line of
+// every entry. A policy file holds one rule per line:
+//
+//	append console=ttyMSM0,115200   add parameters
+//	remove quiet splash             drop parameters by key, or exact key=value
+//	replace root=/dev/mmcblk0p2     change the value of parameters present
+//	match source=bls title=*Lab*    apply the following rules only to matching entries
+//	match all                       apply the following rules to every entry
+//
+// Conditions of a match line are glob patterns on title, version, source
+// and id, all of which must match.
+type CmdlinePolicy struct {
+	Rules []CmdlineRule
+}
+
+// CmdlineRule is one rule of a command line policy
+type CmdlineRule struct {
+	Action     string            // append, remove or replace
+	Params     []string          // Parameters or keys the action applies to
+	Conditions map[string]string // Entry field to glob pattern, empty for all entries
+}
+
+// cmdlineConditions are the entry fields a match line can test
+var cmdlineConditions = map[string]func(e *BootEntry) string{
+	"title":   func(e *BootEntry) string { return e.Title },
+	"version": func(e *BootEntry) string { return e.Version },
+	"source":  func(e *BootEntry) string { return e.Source },
+	"id":      func(e *BootEntry) string { return e.ID },
+}
+
+// ParseCmdlinePolicy parses a command line policy file. Errors name the
+// offending line.
+func ParseCmdlinePolicy(policyPath string) (*CmdlinePolicy, error) {
+	file, err := os.Open(policyPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	policy := &CmdlinePolicy{}
+	var conditions map[string]string
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		action, rest := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			action, rest = line[:i], line[i+1:]
+		}
+		params := SplitCmdline(rest)
+		if len(params) == 0 {
+			return nil, fmt.Errorf("%s:%d: %s needs an argument", policyPath, lineNum, action)
+		}
+
+		switch action {
+		case "match":
+			conditions, err = parseConditions(params)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", policyPath, lineNum, err)
+			}
+
+		case "append", "remove":
+			policy.Rules = append(policy.Rules, CmdlineRule{Action: action, Params: params, Conditions: conditions})
+
+		case "replace":
+			for _, param := range params {
+				if !strings.Contains(param, "=") {
+					return nil, fmt.Errorf("%s:%d: replace needs key=value, got %q", policyPath, lineNum, param)
+				}
+			}
+			policy.Rules = append(policy.Rules, CmdlineRule{Action: action, Params: params, Conditions: conditions})
+
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown action %q", policyPath, lineNum, action)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// parseConditions parses the field=pattern list of a match line
+func parseConditions(params []string) (map[string]string, error) {
+	if len(params) == 1 && params[0] == "all" {
+		return nil, nil
+	}
+
+	conditions := make(map[string]string)
+	for _, param := range params {
+		field, pattern, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, fmt.Errorf("condition %q is not field=pattern", param)
+		}
+		if _, known := cmdlineConditions[field]; !known {
+			return nil, fmt.Errorf("unknown condition field %q", field)
+		}
+		pattern = strings.Trim(pattern, `"`)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		conditions[field] = pattern
+	}
+	return conditions, nil
+}
+
+// Matches reports whether the rule applies to an entry
+func (r CmdlineRule) Matches(e *BootEntry) bool {
+	for field, pattern := range r.Conditions {
+		if ok, _ := path.Match(pattern, cmdlineConditions[field](e)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns the entry's command line rewritten by the matching rules,
+// in file order
+func (p *CmdlinePolicy) Apply(e *BootEntry) string {
+	params := SplitCmdline(e.Options)
+
+	for _, rule := range p.Rules {
+		if !rule.Matches(e) {
+			continue
+		}
+
+		switch rule.Action {
+		case "append":
+			for _, param := range rule.Params {
+				if !containsParam(params, param) {
+					params = append(params, param)
+				}
+			}
+
+		case "remove":
+			for _, param := range rule.Params {
+				params = removeParam(params, param)
+			}
+
+		case "replace":
+			for _, param := range rule.Params {
+				key := paramKey(param)
+				for i := range params {
+					if paramKey(params[i]) == key {
+						params[i] = param
+					}
+				}
+			}
+		}
+	}
+
+	return strings.Join(params, " ")
+}
+
+// SplitCmdline splits a kernel command line into parameters. Double quotes
+// keep spaces inside a parameter, as the kernel does.
+func SplitCmdline(cmdline string) []string {
+	var params []string
+	var current strings.Builder
+	inQuotes := false
+
+	for _, r := range cmdline {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case (r == ' ' || r == '\t' || r == '\n') && !inQuotes:
+			if current.Len() > 0 {
+				params = append(params, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		params = append(params, current.String())
+	}
+	return params
+}
+
+// paramKey returns the key of a key=value parameter, or the whole parameter
+func paramKey(param string) string {
+	key, _, _ := strings.Cut(param, "=")
+	return key
+}
+
+// containsParam checks if params holds param exactly
+func containsParam(params []string, param string) bool {
+	for _, p := range params {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
+// removeParam drops every parameter with the key of param, or only exact
+// matches if param has a value
+func removeParam(params []string, param string) []string {
+	var kept []string
+	for _, p := range params {
+		if p == param || !strings.Contains(param, "=") && paramKey(p) == param {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
@@ -0,0 +1,228 @@
+package entry
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/timoxa0/kxmenu/fdt"
+)
+
+// Board identifies the running machine by its devicetree
+type Board struct {
+	Compatible []string // Most specific first
+	Model      string
+}
+
+// ReadBoard reads the running board's compatible strings and model. It
+// returns nil on machines without a devicetree.
+func ReadBoard() *Board {
+	data, err := os.ReadFile("/proc/device-tree/compatible")
+	if err != nil {
+		return nil
+	}
+
+	board := &Board{}
+	for _, s := range strings.Split(string(data), "\x00") {
+		if s != "" {
+			board.Compatible = append(board.Compatible, s)
+		}
+	}
+	if model, err := os.ReadFile("/proc/device-tree/model"); err == nil {
+		board.Model = strings.TrimRight(string(model), "\x00\n")
+	}
+	return board
+}
+
+// dtbSearchDirs are the directories below the boot root searched for
+// entries that name neither a devicetree nor a devicetree directory.
+// %s is replaced by the entry's version.
+var dtbSearchDirs = []string{
+	"/dtbs/%s",
+	"/dtb-%s",
+	"/dtbs",
+	"/dtb",
+}
+
+// dtbMatch is a candidate DTB and how well it matches the board
+type dtbMatch struct {
+	path       string // Relative to the boot root
+	boardIndex int    // Index of the matched string in the board's compatible list
+	dtbIndex   int    // Index of the matched string in the DTB's compatible list
+	model      bool   // Whether the DTB model equals the board model
+}
+
+// better reports whether m is a closer match than other
+func (m dtbMatch) better(other dtbMatch) bool {
+	if m.boardIndex != other.boardIndex {
+		return m.boardIndex < other.boardIndex
+	}
+	if m.dtbIndex != other.dtbIndex {
+		return m.dtbIndex < other.dtbIndex
+	}
+	if m.model != other.model {
+		return m.model
+	}
+	return m.path < other.path
+}
+
+// SelectDevicetree chooses a DTB for an entry without a devicetree by
+// comparing the root compatible strings of the DTBs in its devicetree
+// directory, or the usual DTB directories, with the running board. The
+// most specific board compatible string wins, then the DTB listing it
+// first, then a matching model. Paths are relative to bootRoot.
+// Entries that boot no plain kernel, or machines without a devicetree,
+// are left alone.
+func (e *BootEntry) SelectDevicetree(bootRoot string, board *Board) {
+	if e.Devicetree != "" || e.Linux == "" || board == nil || len(board.Compatible) == 0 {
+		return
+	}
+
+	dirs := []string{e.DevicetreeDir}
+	if e.DevicetreeDir == "" {
+		dirs = nil
+		for _, dir := range dtbSearchDirs {
+			if strings.Contains(dir, "%s") {
+				if e.Version == "" {
+					continue
+				}
+				dir = fmt.Sprintf(dir, e.Version)
+			}
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range dirs {
+		match, found := findBestDTB(bootRoot, dir, board)
+		if !found {
+			continue
+		}
+
+		e.Devicetree = match.path
+		e.DevicetreeReason = fmt.Sprintf("compatible %q", board.Compatible[match.boardIndex])
+		if match.boardIndex > 0 {
+			e.DevicetreeReason += " (fallback)"
+		}
+		if match.model {
+			e.DevicetreeReason += fmt.Sprintf(", model %q", board.Model)
+		}
+		return
+	}
+}
+
+// minDTBSize is the size of a flattened device tree header
+const minDTBSize = 40
+
+// dtbIdentity is the root compatible strings and model of a DTB
+type dtbIdentity struct {
+	path       string // Relative to the boot root
+	compatible []string
+	model      string
+}
+
+// dtbCache holds the identities of the DTBs below each boot root and
+// directory, read once per run as every entry searches the same
+// directories
+var dtbCache = struct {
+	sync.Mutex
+	dirs map[[2]string][]dtbIdentity
+}{dirs: make(map[[2]string][]dtbIdentity)}
+
+// findBestDTB finds the DTB in a directory matching the board best
+func findBestDTB(bootRoot, dir string, board *Board) (dtbMatch, bool) {
+	var best dtbMatch
+	found := false
+
+	for _, dtb := range readDTBDir(bootRoot, dir) {
+		for i, want := range board.Compatible {
+			j := slices.Index(dtb.compatible, want)
+			if j < 0 {
+				continue
+			}
+
+			match := dtbMatch{
+				path:       dtb.path,
+				boardIndex: i,
+				dtbIndex:   j,
+				model:      board.Model != "" && dtb.model == board.Model,
+			}
+			if !found || match.better(best) {
+				best, found = match, true
+			}
+			break
+		}
+	}
+
+	return best, found
+}
+
+// readDTBDir returns the identities of the DTBs in a directory, scanned
+// recursively, from the cache if it was read before
+func readDTBDir(bootRoot, dir string) []dtbIdentity {
+	dtbCache.Lock()
+	defer dtbCache.Unlock()
+
+	key := [2]string{bootRoot, dir}
+	if dtbs, ok := dtbCache.dirs[key]; ok {
+		return dtbs
+	}
+
+	var dtbs []dtbIdentity
+	root := filepath.Join(bootRoot, dir)
+	filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(d.Name(), ".dtb") {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() < minDTBSize {
+			return nil // Too short for a DTB header
+		}
+
+		compatible, model, err := readDTBIdentity(filePath)
+		if err != nil {
+			return nil // Not a usable DTB
+		}
+
+		rel, _ := filepath.Rel(bootRoot, filePath)
+		dtbs = append(dtbs, dtbIdentity{
+			path:       path.Join("/", filepath.ToSlash(rel)),
+			compatible: compatible,
+			model:      model,
+		})
+		return nil
+	})
+
+	dtbCache.dirs[key] = dtbs
+	return dtbs
+}
+
+// readDTBIdentity returns the root compatible strings and model of a DTB.
+// A corrupt DTB is an error, never a crash before the menu is shown.
+func readDTBIdentity(dtbPath string) (compatible []string, model string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			compatible, model, err = nil, "", fmt.Errorf("%s: corrupt devicetree: %v", dtbPath, r)
+		}
+	}()
+
+	data, err := os.ReadFile(dtbPath)
+	if err != nil {
+		return nil, "", err
+	}
+	tree, err := fdt.Parse(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if prop := tree.Root.Property("compatible"); prop != nil {
+		compatible = prop.Strings()
+	}
+	if prop := tree.Root.Property("model"); prop != nil {
+		model = prop.String()
+	}
+	return compatible, model, nil
+}
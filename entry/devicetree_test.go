@@ -0,0 +1,86 @@
+package entry
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/timoxa0/kxmenu/fdt"
+)
+
+// writeDTB writes a DTB with the given root compatible strings and model
+func writeDTB(t *testing.T, path, model string, compatible ...string) {
+	t.Helper()
+	var value []byte
+	for _, s := range compatible {
+		value = append(append(value, s...), 0)
+	}
+	tree := &fdt.Tree{Root: &fdt.Node{Properties: []*fdt.Property{
+		{Name: "compatible", Value: value},
+		{Name: "model", Value: append([]byte(model), 0)},
+	}}}
+	writeFile(t, path, tree.Marshal())
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelectDevicetree(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "dtbs", "6.1.0")
+	writeDTB(t, filepath.Join(dir, "vendor", "board-a.dtb"), "Board A", "vendor,board-a", "vendor,soc")
+	writeDTB(t, filepath.Join(dir, "vendor", "board-b.dtb"), "Board B", "vendor,board-b", "vendor,soc")
+	writeDTB(t, filepath.Join(dir, "vendor", "soc-evk.dtb"), "EVK", "vendor,soc")
+
+	tests := []struct {
+		board  Board
+		want   string
+		reason string
+	}{
+		{Board{Compatible: []string{"vendor,board-b", "vendor,soc"}}, "/dtbs/6.1.0/vendor/board-b.dtb", `compatible "vendor,board-b"`},
+		{Board{Compatible: []string{"vendor,board-c", "vendor,soc"}}, "/dtbs/6.1.0/vendor/soc-evk.dtb", `compatible "vendor,soc" (fallback)`},
+		{Board{Compatible: []string{"vendor,board-a"}, Model: "Board A"}, "/dtbs/6.1.0/vendor/board-a.dtb", `compatible "vendor,board-a", model "Board A"`},
+		{Board{Compatible: []string{"other,board"}}, "", ""},
+	}
+
+	for _, tt := range tests {
+		e := &BootEntry{Linux: "/vmlinuz", Version: "6.1.0"}
+		e.SelectDevicetree(root, &tt.board)
+		if e.Devicetree != tt.want || e.DevicetreeReason != tt.reason {
+			t.Errorf("SelectDevicetree(%v) = %q, %q, want %q, %q", tt.board.Compatible, e.Devicetree, e.DevicetreeReason, tt.want, tt.reason)
+		}
+	}
+}
+
+// TestSelectDevicetreeCorrupt checks that corrupt DTBs in a scanned
+// directory are skipped and the others still found
+func TestSelectDevicetreeCorrupt(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "dtbs")
+
+	// A header claiming a size shorter than itself
+	header := make([]byte, 64)
+	binary.BigEndian.PutUint32(header[0:], 0xd00dfeed)
+	binary.BigEndian.PutUint32(header[4:], 8)
+	binary.BigEndian.PutUint32(header[20:], 17)
+
+	writeFile(t, filepath.Join(dir, "a-empty.dtb"), nil)
+	writeFile(t, filepath.Join(dir, "a-short.dtb"), []byte{0xd0, 0x0d, 0xfe, 0xed})
+	writeFile(t, filepath.Join(dir, "a-header.dtb"), header)
+	writeFile(t, filepath.Join(dir, "a-garbage.dtb"), make([]byte, 4096))
+	writeDTB(t, filepath.Join(dir, "board.dtb"), "Board", "vendor,board")
+
+	e := &BootEntry{Linux: "/vmlinuz"}
+	e.SelectDevicetree(root, &Board{Compatible: []string{"vendor,board"}})
+	if e.Devicetree != "/dtbs/board.dtb" {
+		t.Errorf("SelectDevicetree() = %q, want /dtbs/board.dtb", e.Devicetree)
+	}
+}
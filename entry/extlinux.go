@@ -0,0 +1,172 @@
+package entry
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ExtlinuxConfig holds the entries and menu settings of an extlinux.conf
+type ExtlinuxConfig struct {
+	Entries []*BootEntry
+	Default string // Label of the default entry
+	Timeout int    // Menu timeout in seconds, 0 = no timeout
+}
+
+// extlinuxPaths are the extlinux configuration locations used by U-Boot
+// distro boot, relative to the scanned directory
+var extlinuxPaths = []string{
+	"extlinux/extlinux.conf",
+	"boot/extlinux/extlinux.conf",
+	"syslinux/syslinux.cfg",
+	"boot/syslinux/syslinux.cfg",
+	"syslinux.cfg",
+}
+
+// isExtlinuxFile checks if a filename is an extlinux or syslinux configuration
+func isExtlinuxFile(filename string) bool {
+	return filename == "extlinux.conf" || filename == "syslinux.cfg"
+}
+
+// FindExtlinuxConfig parses the first extlinux configuration found in dir.
+// It returns nil if there is none.
+func FindExtlinuxConfig(dir string) (*ExtlinuxConfig, error) {
+	for _, name := range extlinuxPaths {
+		configPath := filepath.Join(dir, name)
+		if _, err := os.Stat(configPath); err != nil {
+			continue
+		}
+		return ParseExtlinuxConfig(configPath, dir)
+	}
+	return nil, nil
+}
+
+// ParseExtlinuxConfig parses an extlinux.conf or syslinux.cfg file. Relative
+// paths are resolved against the configuration's directory, with baseDir
+// taken as the root of the boot partition. FDTDIR is resolved to a DTB
+// matching the running board where possible.
+func ParseExtlinuxConfig(configPath, baseDir string) (*ExtlinuxConfig, error) {
+	file, err := os.Open(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Directory of the configuration as seen from the boot partition
+	configDir := "/"
+	if rel, err := filepath.Rel(baseDir, filepath.Dir(configPath)); err == nil && !strings.HasPrefix(rel, "..") {
+		configDir = path.Join("/", filepath.ToSlash(rel))
+	}
+	resolve := func(p string) string {
+		if strings.HasPrefix(p, "/") {
+			return p
+		}
+		return path.Join(configDir, p)
+	}
+
+	config := &ExtlinuxConfig{}
+	var current *BootEntry
+	fdtDirs := make(map[*BootEntry]string)
+
+	finish := func() {
+		if current != nil && current.Linux != "" {
+			config.Entries = append(config.Entries, current)
+		}
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value := splitExtlinuxLine(line)
+		if key == "MENU" {
+			key, value = splitExtlinuxLine(value)
+			key = "MENU " + key
+		}
+
+		// Global settings
+		switch key {
+		case "DEFAULT":
+			config.Default = value
+			continue
+		case "TIMEOUT":
+			// Timeout is given in tenths of a second
+			if tenths, err := strconv.Atoi(value); err == nil && tenths > 0 {
+				config.Timeout = (tenths + 9) / 10
+			}
+			continue
+		case "LABEL":
+			finish()
+			current = &BootEntry{
+				ID:       value,
+				Title:    value,
+				FilePath: configPath,
+			}
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		// Label settings
+		switch key {
+		case "MENU LABEL":
+			current.Title = value
+		case "KERNEL", "LINUX":
+			current.Linux = resolve(value)
+		case "INITRD":
+			current.Initrd = nil
+			for _, initrd := range strings.Split(value, ",") {
+				if initrd = strings.TrimSpace(initrd); initrd != "" {
+					current.Initrd = append(current.Initrd, resolve(initrd))
+				}
+			}
+		case "FDT", "DEVICETREE":
+			current.Devicetree = resolve(value)
+		case "FDTDIR", "DEVICETREEDIR":
+			fdtDirs[current] = resolve(value)
+		case "FDTOVERLAYS":
+			current.DevicetreeOverlay = nil
+			for _, overlay := range strings.Fields(value) {
+				current.DevicetreeOverlay = append(current.DevicetreeOverlay, resolve(overlay))
+			}
+		case "APPEND":
+			current.Options = value
+		}
+	}
+	finish()
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// An explicit FDT wins over FDTDIR
+	board := ReadBoard()
+	for _, e := range config.Entries {
+		if dir, ok := fdtDirs[e]; ok && e.Devicetree == "" {
+			e.DevicetreeDir = dir
+			e.SelectDevicetree(baseDir, board)
+		}
+	}
+
+	return config, nil
+}
+
+// splitExtlinuxLine splits a line into its upper-cased keyword and value
+func splitExtlinuxLine(line string) (string, string) {
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return strings.ToUpper(line), ""
+	}
+	return strings.ToUpper(line[:i]), strings.TrimSpace(line[i+1:])
+}
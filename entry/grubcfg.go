@@ -0,0 +1,483 @@
+package entry
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// grubCommand is one parsed GRUB script command. Block commands such as
+// menuentry, submenu and function carry their body.
+type grubCommand struct {
+	args []string // Raw words, expanded only when the command runs
+	body []grubCommand
+}
+
+// grubToken is a word or control token produced by the GRUB script lexer
+type grubToken struct {
+	text string
+	kind grubTokenKind
+	line int
+}
+
+type grubTokenKind int
+
+const (
+	grubWord grubTokenKind = iota
+	grubSeparator
+	grubOpenBrace
+	grubCloseBrace
+)
+
+// ParseGrubConfig parses menuentry and submenu blocks of a grub.cfg file.
+// Only the commands needed to boot with kexec are interpreted: linux,
+// initrd, devicetree, set and search. Conditions are not evaluated, every
+// if takes its first branch.
+func ParseGrubConfig(path string) ([]*BootEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := lexGrubScript(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	commands, rest, err := parseGrubCommands(tokens)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%s:%d: unexpected '}'", path, rest[0].line)
+	}
+
+	p := &grubParser{path: path}
+	p.run(commands, map[string]string{}, "")
+
+	return p.entries, nil
+}
+
+// lexGrubScript splits a GRUB script into tokens. Words keep their quotes
+// so variables can be expanded later with the values current at that point.
+func lexGrubScript(script string) ([]grubToken, error) {
+	var tokens []grubToken
+	var word strings.Builder
+	line := 1
+
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, grubToken{text: word.String(), kind: grubWord, line: line})
+			word.Reset()
+		}
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\n' || c == ';':
+			flush()
+			tokens = append(tokens, grubToken{kind: grubSeparator, line: line})
+			if c == '\n' {
+				line++
+			}
+
+		case c == ' ' || c == '\t' || c == '\r':
+			flush()
+
+		case c == '#' && word.Len() == 0:
+			// Comment runs to the end of the line
+			for i+1 < len(script) && script[i+1] != '\n' {
+				i++
+			}
+
+		case (c == '{' || c == '}') && word.Len() == 0:
+			kind := grubOpenBrace
+			if c == '}' {
+				kind = grubCloseBrace
+			}
+			tokens = append(tokens, grubToken{text: string(c), kind: kind, line: line})
+
+		case c == '\\':
+			word.WriteByte(c)
+			if i+1 < len(script) {
+				i++
+				if script[i] == '\n' {
+					line++
+				}
+				word.WriteByte(script[i])
+			}
+
+		case c == '\'' || c == '"':
+			// Copy the quoted string, quotes included
+			start := i
+			for i++; i < len(script) && script[i] != c; i++ {
+				if c == '"' && script[i] == '\\' {
+					i++
+				}
+				if i < len(script) && script[i] == '\n' {
+					line++
+				}
+			}
+			if i >= len(script) {
+				return nil, fmt.Errorf("line %d: unterminated quote", line)
+			}
+			word.WriteString(script[start : i+1])
+
+		case c == '$' && i+1 < len(script) && script[i+1] == '{':
+			end := strings.IndexByte(script[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated variable reference", line)
+			}
+			word.WriteString(script[i : i+end+1])
+			i += end
+
+		default:
+			word.WriteByte(c)
+		}
+	}
+	flush()
+
+	return tokens, nil
+}
+
+// parseGrubCommands groups tokens into commands until an unmatched '}'
+// and returns the tokens left after it
+func parseGrubCommands(tokens []grubToken) ([]grubCommand, []grubToken, error) {
+	var commands []grubCommand
+	var current grubCommand
+
+	flush := func() {
+		if len(current.args) > 0 {
+			commands = append(commands, current)
+		}
+		current = grubCommand{}
+	}
+
+	for len(tokens) > 0 {
+		tok := tokens[0]
+		tokens = tokens[1:]
+
+		switch tok.kind {
+		case grubWord:
+			current.args = append(current.args, tok.text)
+
+		case grubSeparator:
+			flush()
+
+		case grubOpenBrace:
+			body, rest, err := parseGrubCommands(tokens)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(rest) == 0 {
+				return nil, nil, fmt.Errorf("line %d: missing '}'", tok.line)
+			}
+			current.body = body
+			tokens = rest[1:]
+			flush()
+
+		case grubCloseBrace:
+			flush()
+			return commands, append([]grubToken{tok}, tokens...), nil
+		}
+	}
+	flush()
+
+	return commands, nil, nil
+}
+
+// grubParser interprets parsed commands and collects boot entries
+type grubParser struct {
+	path    string
+	entries []*BootEntry
+}
+
+// run executes commands with the given variables. Entries found inside
+// submenus get the submenu titles as a prefix.
+func (p *grubParser) run(commands []grubCommand, vars map[string]string, prefix string) {
+	for _, cmd := range activeGrubCommands(commands) {
+		switch cmd.args[0] {
+		case "menuentry":
+			p.addMenuEntry(cmd, copyVars(vars), prefix)
+
+		case "submenu":
+			title, _ := grubEntryTitle(cmd.args[1:], vars)
+			p.run(cmd.body, copyVars(vars), prefix+title+">")
+
+		default:
+			runGrubAssignment(cmd.args, vars)
+		}
+	}
+}
+
+// addMenuEntry runs the body of a menuentry and records it if it boots linux
+func (p *grubParser) addMenuEntry(cmd grubCommand, vars map[string]string, prefix string) {
+	title, id := grubEntryTitle(cmd.args[1:], vars)
+	e := &BootEntry{
+		Title:    prefix + title,
+		ID:       id,
+		FilePath: p.path,
+	}
+
+	for _, body := range activeGrubCommands(cmd.body) {
+		if runGrubAssignment(body.args, vars) {
+			continue
+		}
+
+		words := make([]string, len(body.args))
+		for i, arg := range body.args {
+			words[i] = expandGrubWord(arg, vars)
+		}
+
+		switch words[0] {
+		case "linux", "linux16", "linuxefi":
+			if len(words) < 2 {
+				continue
+			}
+			e.Linux, e.Device = splitGrubPath(words[1], vars)
+			e.Options = strings.Join(strings.Fields(strings.Join(words[2:], " ")), " ")
+		case "initrd", "initrd16", "initrdefi":
+			e.Initrd = nil
+			for _, word := range words[1:] {
+				path, _ := splitGrubPath(word, vars)
+				e.Initrd = append(e.Initrd, path)
+			}
+		case "devicetree":
+			if len(words) > 1 {
+				e.Devicetree, _ = splitGrubPath(words[1], vars)
+			}
+		}
+	}
+
+	// Skip chainloader, firmware setup and similar entries
+	if e.Linux == "" {
+		return
+	}
+
+	p.entries = append(p.entries, e)
+}
+
+// activeGrubCommands returns the commands that run when every condition is
+// true: the first branch of each if, with the keywords removed. Loop bodies
+// run once.
+func activeGrubCommands(commands []grubCommand) []grubCommand {
+	var active []grubCommand
+	var inElse []bool // One flag per nested if
+
+	for _, cmd := range commands {
+		args := cmd.args
+		switch args[0] {
+		case "if", "while", "until":
+			inElse = append(inElse, false)
+			continue
+		case "elif":
+			if len(inElse) > 0 {
+				inElse[len(inElse)-1] = true
+			}
+			continue
+		case "fi", "done":
+			if len(inElse) > 0 {
+				inElse = inElse[:len(inElse)-1]
+			}
+			continue
+		case "else":
+			if len(inElse) > 0 {
+				inElse[len(inElse)-1] = true
+			}
+			args = args[1:]
+		case "then", "do":
+			args = args[1:]
+		}
+
+		skip := false
+		for _, s := range inElse {
+			skip = skip || s
+		}
+		if skip || len(args) == 0 {
+			continue
+		}
+
+		cmd.args = args
+		active = append(active, cmd)
+	}
+
+	return active
+}
+
+// runGrubAssignment applies set, search and name=value commands to vars
+// and reports whether the command was one of them
+func runGrubAssignment(args []string, vars map[string]string) bool {
+	switch {
+	case args[0] == "set":
+		for _, arg := range args[1:] {
+			name, value, _ := strings.Cut(expandGrubWord(arg, vars), "=")
+			vars[name] = value
+		}
+	case args[0] == "search":
+		runGrubSearch(args[1:], vars)
+	case len(args) == 1 && isGrubAssignment(args[0]):
+		name, value, _ := strings.Cut(args[0], "=")
+		vars[name] = expandGrubWord(value, vars)
+	default:
+		return false
+	}
+	return true
+}
+
+// isGrubAssignment reports whether word has the form name=value
+func isGrubAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" || isDigit(name[0]) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isDigit(name[i]) && !isLetter(name[i]) && name[i] != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// grubEntryTitle returns the title and --id of a menuentry or submenu
+func grubEntryTitle(args []string, vars map[string]string) (string, string) {
+	title, id := "", ""
+	for i := 0; i < len(args); i++ {
+		word := expandGrubWord(args[i], vars)
+		switch {
+		case word == "--id" && i+1 < len(args):
+			i++
+			id = expandGrubWord(args[i], vars)
+		case strings.HasPrefix(word, "--id="):
+			id = strings.TrimPrefix(word, "--id=")
+		case word == "--class" || word == "--users" || word == "--hotkey":
+			i++
+		case strings.HasPrefix(word, "-"):
+		case title == "":
+			title = word
+		}
+	}
+	return title, id
+}
+
+// runGrubSearch records the device a search command would find in the
+// variable named by --set, as UUID=... or LABEL=...
+func runGrubSearch(args []string, vars map[string]string) {
+	kind := "file"
+	target := "root"
+	value := ""
+
+	for i := 0; i < len(args); i++ {
+		word := expandGrubWord(args[i], vars)
+		switch {
+		case word == "--fs-uuid" || word == "-u":
+			kind = "UUID"
+		case word == "--label" || word == "-l":
+			kind = "LABEL"
+		case word == "--file" || word == "-f":
+			kind = "file"
+		case word == "--set" || word == "-s":
+			// The variable name is optional
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") && len(args)-i > 2 {
+				i++
+				target = expandGrubWord(args[i], vars)
+			}
+		case strings.HasPrefix(word, "--set="):
+			target = strings.TrimPrefix(word, "--set=")
+		case strings.HasPrefix(word, "-"):
+		default:
+			value = word
+		}
+	}
+
+	// A file search cannot be resolved without probing devices
+	if value == "" || kind == "file" {
+		return
+	}
+	vars[target] = kind + "=" + value
+}
+
+// splitGrubPath splits a GRUB path like (hd0,gpt2)/vmlinuz into the path
+// and its device. Paths without a device are on $root.
+func splitGrubPath(path string, vars map[string]string) (string, string) {
+	if strings.HasPrefix(path, "(") {
+		if end := strings.IndexByte(path, ')'); end > 0 {
+			return path[end+1:], path[1:end]
+		}
+	}
+	return path, vars["root"]
+}
+
+// expandGrubWord removes quoting from a word and expands variables
+// outside single quotes
+func expandGrubWord(word string, vars map[string]string) string {
+	var out strings.Builder
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		switch c {
+		case '\\':
+			if i+1 < len(word) {
+				i++
+				out.WriteByte(word[i])
+			}
+		case '\'':
+			end := strings.IndexByte(word[i+1:], '\'')
+			if end < 0 {
+				end = len(word) - i - 1
+			}
+			out.WriteString(word[i+1 : i+1+end])
+			i += end + 1
+		case '"':
+			for i++; i < len(word) && word[i] != '"'; i++ {
+				switch {
+				case word[i] == '\\' && i+1 < len(word) && strings.IndexByte("\"\\$", word[i+1]) >= 0:
+					i++
+					out.WriteByte(word[i])
+				case word[i] == '$':
+					value, n := expandGrubVar(word[i:], vars)
+					out.WriteString(value)
+					i += n - 1
+				default:
+					out.WriteByte(word[i])
+				}
+			}
+		case '$':
+			value, n := expandGrubVar(word[i:], vars)
+			out.WriteString(value)
+			i += n - 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+// expandGrubVar expands the variable reference at the start of s and
+// returns its value and the length of the reference
+func expandGrubVar(s string, vars map[string]string) (string, int) {
+	if strings.HasPrefix(s, "${") {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return s, len(s)
+		}
+		return vars[s[2:end]], end + 1
+	}
+
+	n := 1
+	for n < len(s) && (isDigit(s[n]) || isLetter(s[n]) || s[n] == '_') {
+		n++
+	}
+	if n == 1 {
+		return "$", 1
+	}
+	return vars[s[1:n]], n
+}
+
+// copyVars returns a copy of a variable scope
+func copyVars(vars map[string]string) map[string]string {
+	scope := make(map[string]string, len(vars))
+	for name, value := range vars {
+		scope[name] = value
+	}
+	return scope
+}
@@ -0,0 +1,363 @@
+package entry
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// grubEntry holds the fields of a BootEntry read from a grub.cfg
+type grubEntry struct {
+	Title, ID, Linux, Device, Options, Devicetree string
+	Initrd                                        []string
+}
+
+// parseGrubScript parses a grub.cfg holding script
+func parseGrubScript(t *testing.T, script string) ([]grubEntry, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "grub.cfg")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return readGrubEntries(t, path)
+}
+
+// readGrubEntries parses a grub.cfg file and returns its entries
+func readGrubEntries(t *testing.T, path string) ([]grubEntry, error) {
+	t.Helper()
+	entries, err := ParseGrubConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var got []grubEntry
+	for _, e := range entries {
+		if e.FilePath != path {
+			t.Errorf("entry %q FilePath = %q, want %q", e.Title, e.FilePath, path)
+		}
+		got = append(got, grubEntry{e.Title, e.ID, e.Linux, e.Device, e.Options, e.Devicetree, e.Initrd})
+	}
+	return got, nil
+}
+
+func TestExpandGrubWord(t *testing.T) {
+	vars := map[string]string{
+		"root":    "hd0,gpt2",
+		"version": "6.1.0",
+		"opts":    "ro  quiet",
+	}
+
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"plain", "plain"},
+		{"'single quoted $version'", "single quoted $version"},
+		{`"double quoted $version"`, "double quoted 6.1.0"},
+		{`"${opts}"`, "ro  quiet"},
+		{"/vmlinuz-$version", "/vmlinuz-6.1.0"},
+		{"/vmlinuz-${version}-arm64", "/vmlinuz-6.1.0-arm64"},
+		{"$undefined", ""},
+		{"($root)/boot", "(hd0,gpt2)/boot"},
+		{`a\ b`, "a b"},
+		{`\$version`, "$version"},
+		{`"\"quoted\" \$version \\ \n"`, `"quoted" $version \ \n`},
+		{`'it'\''s'`, "it's"},
+		{`pre"mid $version"'post $version'`, "premid 6.1.0post $version"},
+		{"cost$", "cost$"},
+		{"${unterminated", "${unterminated"},
+	}
+
+	for _, tt := range tests {
+		if got := expandGrubWord(tt.word, vars); got != tt.want {
+			t.Errorf("expandGrubWord(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestLexGrubScript(t *testing.T) {
+	tests := []struct {
+		script string
+		want   []string // Words, with ; for separators
+		err    bool
+	}{
+		{"linux /vmlinuz ro", []string{"linux", "/vmlinuz", "ro"}, false},
+		{"echo 'a b'  \"c  d\"", []string{"echo", "'a b'", `"c  d"`}, false},
+		{"a;b\nc", []string{"a", ";", "b", ";", "c"}, false},
+		{"set x=1 # comment\n", []string{"set", "x=1", ";"}, false},
+		{"echo a#b", []string{"echo", "a#b"}, false},
+		{"menuentry 'x' {\nlinux /k\n}", []string{"menuentry", "'x'", "{", ";", "linux", "/k", ";", "}"}, false},
+		{"echo a{b}", []string{"echo", "a{b}"}, false},
+		{"echo ${a b}c", []string{"echo", "${a b}c"}, false},
+		{"linux /k \\\n ro", []string{"linux", "/k", "\\\n", "ro"}, false},
+		{"echo 'a\nb' c", []string{"echo", "'a\nb'", "c"}, false},
+		{"echo 'unterminated", nil, true},
+		{"echo \"unterminated \\\"", nil, true},
+		{"echo ${unterminated", nil, true},
+	}
+
+	for _, tt := range tests {
+		tokens, err := lexGrubScript(tt.script)
+		if tt.err {
+			if err == nil {
+				t.Errorf("lexGrubScript(%q) succeeded", tt.script)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("lexGrubScript(%q) error: %v", tt.script, err)
+			continue
+		}
+
+		var got []string
+		for _, tok := range tokens {
+			if tok.kind == grubSeparator {
+				got = append(got, ";")
+			} else {
+				got = append(got, tok.text)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lexGrubScript(%q) = %q, want %q", tt.script, got, tt.want)
+		}
+	}
+
+	// Lines are counted through quotes and escaped newlines
+	tokens, _ := lexGrubScript("a 'b\nc' \\\nd\ne")
+	if last := tokens[len(tokens)-1]; last.text != "e" || last.line != 4 {
+		t.Errorf("token %q on line %d, want e on line 4", last.text, last.line)
+	}
+}
+
+func TestParseGrubConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []grubEntry
+	}{
+		{
+			name: "quoted title and id",
+			script: `menuentry "Linux $version" --class os --id=linux-id {
+	linux /vmlinuz root=/dev/sda1
+}
+menuentry 'Other' --class os --id other-id --unrestricted {
+	linux /vmlinuz
+}`,
+			want: []grubEntry{
+				{Title: "Linux ", ID: "linux-id", Linux: "/vmlinuz", Options: "root=/dev/sda1"},
+				{Title: "Other", ID: "other-id", Linux: "/vmlinuz"},
+			},
+		},
+		{
+			name: "variables",
+			script: `set version=6.1.0 opts="ro  quiet"
+kernel=/vmlinuz-$version
+menuentry "Linux $version" {
+	set extra='$literal'
+	linux $kernel $opts "$extra" ${missing}
+	initrd /initrd-${version}.img /microcode.img
+	devicetree /dtbs/$version/board.dtb
+}`,
+			want: []grubEntry{{
+				Title:      "Linux 6.1.0",
+				Linux:      "/vmlinuz-6.1.0",
+				Options:    "ro quiet $literal",
+				Initrd:     []string{"/initrd-6.1.0.img", "/microcode.img"},
+				Devicetree: "/dtbs/6.1.0/board.dtb",
+			}},
+		},
+		{
+			name: "variables set in an entry stay in it",
+			script: `set opts=global
+menuentry a {
+	set opts=local
+	linux /a $opts
+}
+menuentry b {
+	linux /b $opts
+}`,
+			want: []grubEntry{
+				{Title: "a", Linux: "/a", Options: "local"},
+				{Title: "b", Linux: "/b", Options: "global"},
+			},
+		},
+		{
+			name: "devices",
+			script: `menuentry root {
+	set root='hd0,gpt2'
+	linux /vmlinuz
+	initrd (hd0,gpt3)/initrd.img
+}
+menuentry search {
+	search --no-floppy --fs-uuid --set=root 1234-abcd
+	linux /vmlinuz
+}
+menuentry label {
+	search --label --set boot BOOT
+	linux ($boot)/vmlinuz
+}
+menuentry file {
+	set root=hd1
+	search --file --set=root /vmlinuz
+	linux /vmlinuz
+}`,
+			want: []grubEntry{
+				{Title: "root", Linux: "/vmlinuz", Device: "hd0,gpt2", Initrd: []string{"/initrd.img"}},
+				{Title: "search", Linux: "/vmlinuz", Device: "UUID=1234-abcd"},
+				{Title: "label", Linux: "/vmlinuz", Device: "LABEL=BOOT"},
+				{Title: "file", Linux: "/vmlinuz", Device: "hd1"},
+			},
+		},
+		{
+			name: "if takes the first branch",
+			script: `if [ x$feature = xy ]; then
+	set opts=first
+	if [ -n "$a" ]; then set inner=yes; else set inner=no; fi
+elif [ x$other = xy ]; then
+	set opts=elif
+else
+	set opts=else
+	menuentry hidden {
+		linux /hidden
+	}
+fi
+menuentry shown {
+	if [ x$grub_platform = xefi ]; then
+		linux /vmlinuz.efi $opts $inner
+	else
+		linux /vmlinuz $opts $inner
+	fi
+}`,
+			want: []grubEntry{{Title: "shown", Linux: "/vmlinuz.efi", Options: "first yes"}},
+		},
+		{
+			name: "submenus",
+			script: `set opts=outer
+submenu "Advanced" --id advanced {
+	set opts=submenu
+	menuentry "Kernel" --id kernel {
+		linux /vmlinuz $opts
+	}
+	submenu 'Older' {
+		menuentry "Old" {
+			linux /vmlinuz-old $opts
+		}
+	}
+}
+menuentry "After" {
+	linux /vmlinuz $opts
+}`,
+			want: []grubEntry{
+				{Title: "Advanced>Kernel", ID: "kernel", Linux: "/vmlinuz", Options: "submenu"},
+				{Title: "Advanced>Older>Old", Linux: "/vmlinuz-old", Options: "submenu"},
+				{Title: "After", Linux: "/vmlinuz", Options: "outer"},
+			},
+		},
+		{
+			name: "entries without linux",
+			script: `menuentry 'UEFI Firmware Settings' {
+	fwsetup
+}
+menuentry 'Windows' {
+	chainloader /EFI/Microsoft/Boot/bootmgfw.efi
+}
+function load_video {
+	insmod all_video
+}`,
+		},
+		{
+			name:   "one line",
+			script: `menuentry 'x' { linux /vmlinuz quiet; initrd /initrd.img; }`,
+			want:   []grubEntry{{Title: "x", Linux: "/vmlinuz", Options: "quiet", Initrd: []string{"/initrd.img"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGrubScript(t, tt.script)
+			if err != nil {
+				t.Fatalf("ParseGrubConfig() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseGrubConfig() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGrubConfigErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing brace":    "menuentry x {\n\tlinux /vmlinuz\n",
+		"unexpected brace": "menuentry x {\n\tlinux /vmlinuz\n}\n}\n",
+		"open quote":       "menuentry 'x {\n\tlinux /vmlinuz\n}\n",
+	}
+
+	for name, script := range tests {
+		if _, err := parseGrubScript(t, script); err == nil {
+			t.Errorf("ParseGrubConfig(%s) succeeded", name)
+		}
+	}
+}
+
+func TestParseGrubConfigSamples(t *testing.T) {
+	const debianUUID = "3f1c7b52-9d4e-4c5a-8a55-2f0c6d1e7a90"
+	debianKernel := grubEntry{
+		Linux:   "/boot/vmlinuz-6.1.0-18-amd64",
+		Device:  "UUID=" + debianUUID,
+		Options: "root=UUID=" + debianUUID + " ro quiet",
+		Initrd:  []string{"/boot/initrd.img-6.1.0-18-amd64"},
+	}
+	debianSimple := debianKernel
+	debianSimple.Title = "Debian GNU/Linux"
+	debianSimple.ID = "gnulinux-simple-" + debianUUID
+	debianAdvanced := debianKernel
+	debianAdvanced.Title = "Advanced options for Debian GNU/Linux>Debian GNU/Linux, with Linux 6.1.0-18-amd64"
+	debianAdvanced.ID = "gnulinux-6.1.0-18-amd64-advanced-" + debianUUID
+	debianRecovery := debianKernel
+	debianRecovery.Title = "Advanced options for Debian GNU/Linux>Debian GNU/Linux, with Linux 6.1.0-18-amd64 (recovery mode)"
+	debianRecovery.ID = "gnulinux-6.1.0-18-amd64-recovery-" + debianUUID
+	debianRecovery.Options = "root=UUID=" + debianUUID + " ro single"
+
+	const fedoraOptions = "root=/dev/mapper/fedora-root ro resume=/dev/mapper/fedora-swap rd.lvm.lv=fedora/root rd.lvm.lv=fedora/swap rhgb quiet"
+	const fedoraDevice = "UUID=5e6f7a8b-9c0d-4e1f-a2b3-c4d5e6f7a8b9"
+	const rescue = "0-rescue-0f3c2b1a9d8e7f6a5b4c3d2e1f0a9b8c"
+
+	tests := []struct {
+		file string
+		want []grubEntry
+	}{
+		{"grub-debian.cfg", []grubEntry{debianSimple, debianAdvanced, debianRecovery}},
+		{"grub-fedora.cfg", []grubEntry{
+			{
+				Title:   "Fedora (4.18.16-300.fc29.x86_64) 29 (Twenty Nine)",
+				ID:      "gnulinux-4.18.16-300.fc29.x86_64-advanced-9b2a0c3e-1d47-4f5e-b6a2-7c8d9e0f1a2b",
+				Linux:   "/vmlinuz-4.18.16-300.fc29.x86_64",
+				Device:  fedoraDevice,
+				Options: fedoraOptions + " LANG=en_US.UTF-8",
+				Initrd:  []string{"/initramfs-4.18.16-300.fc29.x86_64.img"},
+			},
+			{
+				Title:   "Fedora (" + rescue + ") 29 (Twenty Nine)",
+				ID:      "gnulinux-" + rescue + "-advanced-9b2a0c3e-1d47-4f5e-b6a2-7c8d9e0f1a2b",
+				Linux:   "/vmlinuz-" + rescue,
+				Device:  fedoraDevice,
+				Options: fedoraOptions,
+				Initrd:  []string{"/initramfs-" + rescue + ".img"},
+			},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.TrimSuffix(tt.file, ".cfg"), func(t *testing.T) {
+			got, err := readGrubEntries(t, filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatalf("ParseGrubConfig() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseGrubConfig() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
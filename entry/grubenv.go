@@ -0,0 +1,191 @@
+package entry
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	grubEnvHeader = "# GRUB Environment Block\n"
+	grubEnvSize   = 1024
+)
+
+// grubEnvPaths are the grubenv locations tried relative to the boot root
+var grubEnvPaths = []string{
+	"grub2/grubenv",
+	"grub/grubenv",
+	"boot/grub2/grubenv",
+	"boot/grub/grubenv",
+}
+
+// varsFileName is the kxmenu variables file used when no grubenv exists
+const varsFileName = "kxmenu.env"
+
+// varPattern matches $name and ${name} references
+var varPattern = regexp.MustCompile(`\$(?:\{([A-Za-z0-9_]+)\}|([A-Za-z0-9_]+))`)
+
+// ReadGrubEnv reads a GRUB environment block file
+func ReadGrubEnv(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) != grubEnvSize {
+		return nil, fmt.Errorf("%s: invalid environment block size %d", path, len(data))
+	}
+	if !bytes.HasPrefix(data, []byte(grubEnvHeader)) {
+		return nil, fmt.Errorf("%s: missing environment block header", path)
+	}
+
+	vars := make(map[string]string)
+	body := data[len(grubEnvHeader):]
+	for len(body) > 0 {
+		// The remainder of the block is padded with '#'
+		if body[0] == '#' {
+			break
+		}
+
+		line, rest := readEnvLine(body)
+		body = rest
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		vars[name] = value
+	}
+
+	return vars, nil
+}
+
+// readEnvLine reads one line of an environment block, resolving backslash
+// escapes so values may contain newlines
+func readEnvLine(data []byte) (string, []byte) {
+	var line strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			if i+1 < len(data) {
+				i++
+				line.WriteByte(data[i])
+			}
+		case '\n':
+			return line.String(), data[i+1:]
+		default:
+			line.WriteByte(data[i])
+		}
+	}
+	return line.String(), nil
+}
+
+// ReadVarsFile reads a kxmenu variables file of name=value lines
+func ReadVarsFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		vars[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+// LoadVariables loads entry variables for a boot root from the first grubenv
+// found, falling back to the kxmenu variables file. A boot root without
+// either yields an empty set.
+func LoadVariables(bootRoot string) (map[string]string, error) {
+	for _, name := range grubEnvPaths {
+		path := filepath.Join(bootRoot, name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		return ReadGrubEnv(path)
+	}
+
+	path := filepath.Join(bootRoot, varsFileName)
+	if _, err := os.Stat(path); err == nil {
+		return ReadVarsFile(path)
+	}
+
+	return map[string]string{}, nil
+}
+
+// submatch returns group i of a match located by FindStringSubmatchIndex
+func submatch(s string, m []int, i int) string {
+	if m[2*i] < 0 {
+		return ""
+	}
+	return s[m[2*i]:m[2*i+1]]
+}
+
+// ExpandVariables expands $name and ${name} references in the entry's options
+// and initrds. Unknown variables are removed and their names returned.
+func (e *BootEntry) ExpandVariables(vars map[string]string) []string {
+	unknown := make(map[string]bool)
+
+	// The blanks following a removed variable are dropped with it, so no
+	// gap is left. Other whitespace, like that in quoted values, is kept.
+	expand := func(s string) string {
+		var b strings.Builder
+		last := 0
+		for _, m := range varPattern.FindAllStringSubmatchIndex(s, -1) {
+			b.WriteString(s[last:m[0]])
+			last = m[1]
+
+			name := submatch(s, m, 1) + submatch(s, m, 2)
+			if value, ok := vars[name]; ok {
+				b.WriteString(value)
+				continue
+			}
+			unknown[name] = true
+
+			rest := strings.TrimLeft(s[last:], " \t")
+			if out := b.String(); out == "" || rest == "" || strings.HasSuffix(out, " ") || strings.HasSuffix(out, "\t") {
+				last = len(s) - len(rest)
+			}
+		}
+		b.WriteString(s[last:])
+		return strings.TrimRight(b.String(), " \t")
+	}
+
+	e.Options = expand(e.Options)
+
+	var initrds []string
+	for _, initrd := range e.Initrd {
+		initrds = append(initrds, strings.Fields(expand(initrd))...)
+	}
+	e.Initrd = initrds
+
+	names := make([]string, 0, len(unknown))
+	for name := range unknown {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
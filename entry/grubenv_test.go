@@ -0,0 +1,44 @@
+package entry
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExpandVariables(t *testing.T) {
+	vars := map[string]string{
+		"kernelopts":   "root=/dev/sda2 ro",
+		"tuned_params": "",
+		"label":        "a  b",
+	}
+
+	tests := []struct {
+		options string
+		want    string
+		unknown []string
+	}{
+		{"$kernelopts quiet", "root=/dev/sda2 ro quiet", nil},
+		{"${kernelopts} $tuned_params", "root=/dev/sda2 ro", nil},
+		{`title="a  b" $missing quiet`, `title="a  b" quiet`, []string{"missing"}},
+		{`$missing title="a  b"`, `title="a  b"`, []string{"missing"}},
+		{`title="a  b" $missing`, `title="a  b"`, []string{"missing"}},
+		{`quiet  splash $a $b end`, `quiet  splash end`, []string{"a", "b"}},
+		{`label="$label"`, `label="a  b"`, nil},
+		{`x=$missing,y`, `x=,y`, []string{"missing"}},
+	}
+
+	for _, tt := range tests {
+		e := &BootEntry{Options: tt.options, Initrd: []string{"/initramfs.img $tuned_params"}}
+		unknown := e.ExpandVariables(vars)
+
+		if e.Options != tt.want {
+			t.Errorf("ExpandVariables(%q) options = %q, want %q", tt.options, e.Options, tt.want)
+		}
+		if !slices.Equal(unknown, tt.unknown) {
+			t.Errorf("ExpandVariables(%q) unknown = %q, want %q", tt.options, unknown, tt.unknown)
+		}
+		if !slices.Equal(e.Initrd, []string{"/initramfs.img"}) {
+			t.Errorf("ExpandVariables(%q) initrd = %q", tt.options, e.Initrd)
+		}
+	}
+}
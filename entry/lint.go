@@ -0,0 +1,187 @@
+package entry
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Severity is the severity of a lint diagnostic
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+// String returns the name of the severity
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Diagnostic is a problem found in a boot entry file
+type Diagnostic struct {
+	File     string
+	Line     int // 0 if the problem concerns the whole file
+	Severity Severity
+	Message  string
+}
+
+// String formats the diagnostic as file:line: severity: message
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s: %s", d.File, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", d.File, d.Line, d.Severity, d.Message)
+}
+
+// knownKeys lists the Boot Loader Specification Type #1 keys and whether
+// they may be repeated
+var knownKeys = map[string]bool{
+	"title":              false,
+	"version":            false,
+	"machine-id":         false,
+	"sort-key":           false,
+	"linux":              false,
+	"initrd":             true,
+	"efi":                false,
+	"options":            true,
+	"devicetree":         false,
+	"devicetree-overlay": true,
+	"architecture":       false,
+}
+
+// fileKeys are the keys whose values are paths under the boot root
+var fileKeys = map[string]bool{
+	"linux":              true,
+	"initrd":             true,
+	"efi":                true,
+	"devicetree":         true,
+	"devicetree-overlay": true,
+}
+
+// LintEntry checks a boot entry file for problems that ParseEntry would
+// silently ignore or that would make the entry fail to boot. Referenced
+// files are looked up under bootRoot.
+func LintEntry(entryFile, bootRoot string) ([]Diagnostic, error) {
+	file, err := os.Open(entryFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	vars, err := LoadVariables(bootRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var diags []Diagnostic
+	report := func(line int, severity Severity, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{
+			File:     entryFile,
+			Line:     line,
+			Severity: severity,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	seen := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// ParseEntry only splits on a space
+		fields := strings.Fields(line)
+		if i := strings.IndexAny(line, " \t"); i >= 0 && line[i] == '\t' {
+			report(lineNum, SeverityError, "key %q is separated from its value by a tab, the line is ignored", fields[0])
+			continue
+		}
+		if len(fields) < 2 {
+			report(lineNum, SeverityWarning, "key %q has no value, the line is ignored", fields[0])
+			continue
+		}
+
+		key := fields[0]
+		value := strings.TrimSpace(line[len(key):])
+
+		repeatable, known := knownKeys[key]
+		if !known {
+			report(lineNum, SeverityWarning, "unknown key %q", key)
+			continue
+		}
+		if first, ok := seen[key]; ok && !repeatable {
+			report(lineNum, SeverityWarning, "duplicate key %q overrides line %d", key, first)
+		}
+		seen[key] = lineNum
+
+		// Variables are expanded at boot, undefined ones are dropped
+		for _, m := range varPattern.FindAllStringSubmatch(value, -1) {
+			name := m[1] + m[2]
+			if _, ok := vars[name]; !ok {
+				report(lineNum, SeverityWarning, "variable $%s is not defined and will be removed", name)
+			}
+		}
+
+		if fileKeys[key] {
+			for _, path := range strings.Fields(value) {
+				if strings.Contains(path, "$") {
+					continue
+				}
+				if _, err := os.Stat(filepath.Join(bootRoot, path)); err != nil {
+					report(lineNum, SeverityError, "%s file %s not found under %s", key, path, bootRoot)
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if seen["linux"] == 0 && seen["efi"] == 0 {
+		report(0, SeverityError, "entry has no linux or efi key")
+	}
+
+	return diags, nil
+}
+
+// LintDir runs LintEntry on every boot entry file found in dir
+func LintDir(dir, bootRoot string) ([]Diagnostic, error) {
+	var diags []Diagnostic
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || isExtlinuxFile(info.Name()) || !isEntryFile(info.Name()) {
+			return nil
+		}
+
+		fileDiags, err := LintEntry(path, bootRoot)
+		if err != nil {
+			return err
+		}
+		diags = append(diags, fileDiags...)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return diags, nil
+}
@@ -0,0 +1,125 @@
+package entry
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LoaderConfig holds the menu settings of a systemd-boot loader.conf
+type LoaderConfig struct {
+	Default    string // Glob pattern matched against entry IDs, or "@saved"
+	Timeout    int    // Menu timeout in seconds, 0 = no timeout
+	TimeoutSet bool   // Whether the file sets a timeout at all
+	Hidden     bool   // Boot the default entry without showing the menu
+	Editor     bool   // Whether the kernel command line may be edited
+}
+
+// loaderConfPaths are the loader.conf locations relative to the scanned directory
+var loaderConfPaths = []string{
+	"loader/loader.conf",
+	"loader.conf",
+}
+
+// FindLoaderConfig parses the loader.conf found in dir. It returns nil if
+// there is none.
+func FindLoaderConfig(dir string) (*LoaderConfig, error) {
+	for _, name := range loaderConfPaths {
+		configPath := filepath.Join(dir, name)
+		if _, err := os.Stat(configPath); err != nil {
+			continue
+		}
+		return ParseLoaderConfig(configPath)
+	}
+	return nil, nil
+}
+
+// ParseLoaderConfig parses a systemd-boot loader.conf file
+func ParseLoaderConfig(configPath string) (*LoaderConfig, error) {
+	file, err := os.Open(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// The editor is enabled unless disabled explicitly
+	config := &LoaderConfig{
+		Editor: true,
+	}
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, _ := strings.Cut(strings.Join(strings.Fields(line), " "), " ")
+
+		switch key {
+		case "default":
+			config.Default = value
+		case "timeout":
+			config.TimeoutSet = true
+			switch value {
+			case "menu-force":
+				config.Timeout = 0
+				config.Hidden = false
+			case "menu-hidden", "0":
+				config.Timeout = 0
+				config.Hidden = true
+			default:
+				seconds, err := strconv.Atoi(value)
+				if err != nil || seconds < 0 {
+					return nil, fmt.Errorf("%s:%d: invalid timeout %q", configPath, lineNum, value)
+				}
+				config.Timeout = seconds
+				config.Hidden = false
+			}
+		case "editor":
+			enabled, err := parseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid editor value %q", configPath, lineNum, value)
+			}
+			config.Editor = enabled
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// parseBool parses the boolean spellings accepted by systemd
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "yes", "y", "true", "t", "on":
+		return true, nil
+	case "0", "no", "n", "false", "f", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", value)
+}
+
+// MatchesID reports whether the entry ID matches a glob pattern. BLS entry
+// IDs match with or without their .conf suffix.
+func (e *BootEntry) MatchesID(pattern string) bool {
+	if matched, _ := path.Match(pattern, e.ID); matched {
+		return true
+	}
+	if id, ok := strings.CutSuffix(e.ID, ".conf"); ok {
+		matched, _ := path.Match(pattern, id)
+		return matched
+	}
+	return false
+}
@@ -4,19 +4,47 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"path/filepath"
+	"runtime"
 	"strings"
 )
 
-// BootEntry represents a boot entry configuration
+// BootEntry represents a Boot Loader Specification Type #1 entry
 type BootEntry struct {
-	Title      string
-	Version    string
-	Linux      string
-	Initrd     string
-	Devicetree string
-	Options    string
-	FilePath   string // Path to the entry file for reference
+	ID                string // Entry identifier, the file name without boot counter for BLS entries
+	Title             string
+	Version           string
+	MachineID         string
+	SortKey           string
+	Linux             string
+	Initrd            []string // Initrds in the order they should be loaded
+	Efi               string
+	AndroidBoot       string // Android boot image holding kernel, ramdisk and DTB
+	AndroidInitBoot   string // Android init_boot image holding the generic ramdisk
+	AndroidVendorBoot string // Android vendor_boot image holding vendor ramdisks and DTB
+	Options           string // All options lines, joined by spaces
+	Devicetree        string
+	DevicetreeDir     string // Directory the devicetree was chosen from, if any
+	DevicetreeReason  string // Why the devicetree was chosen, if chosen automatically
+	DevicetreeOverlay []string
+	Architecture      string
+	Device            string // Device the paths are relative to, if the source names one
+	BootRoot          string // Directory the paths are relative to, overrides --boot-root
+	Source            string // Name of the EntrySource that produced the entry
+	BootCounting      bool   // File name carries a +LEFT-DONE boot counter
+	TriesLeft         int    // Boot attempts left, bad once zero
+	TriesDone         int    // Boot attempts made so far
+	Prepared          bool   // Variables, command line policy and devicetree applied
+	FilePath          string // Path to the entry file for reference
+}
+
+// archNames maps Go architecture names to the BLS architecture identifiers
+var archNames = map[string]string{
+	"386":     "ia32",
+	"amd64":   "x64",
+	"arm":     "arm",
+	"arm64":   "aa64",
+	"riscv64": "riscv64",
+	"loong64": "loongarch64",
 }
 
 // ParseEntry parses a single boot entry configuration file
@@ -30,6 +58,7 @@ func ParseEntry(entryFile string) (*BootEntry, error) {
 	entry := &BootEntry{
 		FilePath: entryFile,
 	}
+	entry.setBootCounter()
 	scanner := bufio.NewScanner(file)
 
 	for scanner.Scan() {
@@ -47,7 +76,7 @@ func ParseEntry(entryFile string) (*BootEntry, error) {
 		}
 
 		key := parts[0]
-		value := parts[1]
+		value := strings.TrimSpace(parts[1])
 
 		// Assign values based on key
 		switch key {
@@ -55,14 +84,30 @@ func ParseEntry(entryFile string) (*BootEntry, error) {
 			entry.Title = value
 		case "version":
 			entry.Version = value
+		case "machine-id":
+			entry.MachineID = value
+		case "sort-key":
+			entry.SortKey = value
 		case "linux":
 			entry.Linux = value
 		case "initrd":
-			entry.Initrd = value
+			// Repeated initrd lines are all loaded, some generators also
+			// put several paths on one line
+			entry.Initrd = append(entry.Initrd, strings.Fields(value)...)
+		case "efi":
+			entry.Efi = value
+		case "options":
+			// Repeated options lines are concatenated
+			if entry.Options != "" {
+				entry.Options += " "
+			}
+			entry.Options += value
 		case "devicetree":
 			entry.Devicetree = value
-		case "options":
-			entry.Options = value
+		case "devicetree-overlay":
+			entry.DevicetreeOverlay = append(entry.DevicetreeOverlay, strings.Fields(value)...)
+		case "architecture":
+			entry.Architecture = strings.ToLower(value)
 		}
 	}
 
@@ -73,50 +118,18 @@ func ParseEntry(entryFile string) (*BootEntry, error) {
 	return entry, nil
 }
 
-// FindEntries searches for boot entry configuration files in a directory
-// It looks for files with .conf extension or specific entry file patterns
+// FindEntries searches a directory for boot entries of every registered source
 func FindEntries(dir string) ([]*BootEntry, error) {
-	var entries []*BootEntry
-
-	// Check if directory exists
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		return nil, fmt.Errorf("directory %s does not exist", dir)
-	}
-
-	// Walk through directory
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip directories
-		if info.IsDir() {
-			return nil
-		}
-
-		// Check for entry files (.conf extension or specific patterns)
-		if isEntryFile(info.Name()) {
-			entry, parseErr := ParseEntry(path)
-			if parseErr != nil {
-				// Log warning but continue processing other files
-				fmt.Fprintf(os.Stderr, "Warning: failed to parse %s: %v\n", path, parseErr)
-				return nil
-			}
-			entries = append(entries, entry)
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return nil, fmt.Errorf("error scanning directory %s: %v", dir, err)
-	}
-
-	return entries, nil
+	return FindEntriesFrom(dir, Sources())
 }
 
 // isEntryFile checks if a filename matches boot entry file patterns
 func isEntryFile(filename string) bool {
+	// loader.conf holds menu settings, not an entry
+	if filename == "loader.conf" {
+		return false
+	}
+
 	// Check for .conf extension
 	if strings.HasSuffix(filename, ".conf") {
 		return true
@@ -138,10 +151,18 @@ func isEntryFile(filename string) bool {
 	return false
 }
 
-// CleanupEntry removes tuned parameters and performs other cleanup
-func (e *BootEntry) CleanupEntry() {
-	e.Initrd = strings.ReplaceAll(e.Initrd, " $tuned_initrd", "")
-	e.Options = strings.ReplaceAll(e.Options, " $tuned_params", "")
+// HostArchitecture returns the BLS architecture identifier of the running machine
+func HostArchitecture() string {
+	if arch, ok := archNames[runtime.GOARCH]; ok {
+		return arch
+	}
+	return runtime.GOARCH
+}
+
+// MatchesHost reports whether the entry can be booted on the running machine.
+// Entries without an architecture key match any machine.
+func (e *BootEntry) MatchesHost() bool {
+	return e.Architecture == "" || e.Architecture == HostArchitecture()
 }
 
 // PrintEntry prints the boot entry information
@@ -152,15 +173,42 @@ func (e *BootEntry) PrintEntry() {
 	if e.Version != "" {
 		fmt.Printf("Version: %s\n", e.Version)
 	}
+	if e.MachineID != "" {
+		fmt.Printf("Machine ID: %s\n", e.MachineID)
+	}
 	if e.Linux != "" {
 		fmt.Printf("Linux: %s\n", e.Linux)
 	}
-	if e.Initrd != "" {
-		fmt.Printf("Initrd: %s\n", e.Initrd)
+	if e.Efi != "" {
+		fmt.Printf("EFI: %s\n", e.Efi)
+	}
+	if e.AndroidBoot != "" {
+		fmt.Printf("Android boot image: %s\n", e.AndroidBoot)
+	}
+	if e.AndroidInitBoot != "" {
+		fmt.Printf("Android init_boot image: %s\n", e.AndroidInitBoot)
+	}
+	if e.AndroidVendorBoot != "" {
+		fmt.Printf("Android vendor_boot image: %s\n", e.AndroidVendorBoot)
+	}
+	for _, initrd := range e.Initrd {
+		fmt.Printf("Initrd: %s\n", initrd)
 	}
 	if e.Devicetree != "" {
 		fmt.Printf("Devicetree: %s\n", e.Devicetree)
 	}
+	if e.DevicetreeReason != "" {
+		fmt.Printf("Devicetree chosen by: %s\n", e.DevicetreeReason)
+	}
+	for _, overlay := range e.DevicetreeOverlay {
+		fmt.Printf("Devicetree overlay: %s\n", overlay)
+	}
+	if e.BootCounting {
+		fmt.Printf("Boot tries: %d left, %d done\n", e.TriesLeft, e.TriesDone)
+	}
+	if e.Architecture != "" {
+		fmt.Printf("Architecture: %s\n", e.Architecture)
+	}
 	if e.Options != "" {
 		fmt.Printf("Options: %s\n", e.Options)
 	}
@@ -0,0 +1,175 @@
+package entry
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SortEntries orders entries as described by the Boot Loader Specification:
+// entries that ran out of boot attempts go last, entries with a sort-key
+// come first, ordered by sort-key, then machine-id, then version (newest
+// first). Ties and entries without a sort-key are ordered by filename,
+// newest version first.
+func SortEntries(entries []*BootEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return CompareEntries(entries[i], entries[j]) < 0
+	})
+}
+
+// CompareEntries returns a negative number if a should be shown before b,
+// a positive number if after, and zero if the order is undefined
+func CompareEntries(a, b *BootEntry) int {
+	// Bad entries go after all others
+	if a.IsBad() != b.IsBad() {
+		if b.IsBad() {
+			return -1
+		}
+		return 1
+	}
+
+	// Entries with a sort-key go before those without
+	if (a.SortKey == "") != (b.SortKey == "") {
+		if a.SortKey != "" {
+			return -1
+		}
+		return 1
+	}
+
+	if a.SortKey != "" {
+		if c := strings.Compare(a.SortKey, b.SortKey); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.MachineID, b.MachineID); c != 0 {
+			return c
+		}
+		// Newer versions first
+		if c := CompareVersions(b.Version, a.Version); c != 0 {
+			return c
+		}
+	}
+
+	// Newer filenames first
+	return CompareVersions(entryFileName(b), entryFileName(a))
+}
+
+// entryFileName returns the entry filename without the boot counter and
+// the .conf extension
+func entryFileName(e *BootEntry) string {
+	name, _, _, _ := parseBootCounter(filepath.Base(e.FilePath))
+	return strings.TrimSuffix(name, ".conf")
+}
+
+// CompareVersions compares two version strings the way rpm and dpkg do:
+// runs of digits are compared numerically, runs of letters lexically, and
+// other characters only separate the runs. A '~' sorts before anything,
+// even the end of the string, so "1.0~rc1" is older than "1.0". A '^'
+// sorts after the end of the string but before any other run.
+// It returns -1, 0 or 1 when a is older than, equal to, or newer than b.
+func CompareVersions(a, b string) int {
+	for a != "" || b != "" {
+		// Skip separators
+		a = strings.TrimLeftFunc(a, isVersionSeparator)
+		b = strings.TrimLeftFunc(b, isVersionSeparator)
+
+		// Tilde sorts before everything
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		// Caret sorts after the end of the string only
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if a == "" {
+				return -1
+			}
+			if b == "" {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if a == "" || b == "" {
+			break
+		}
+
+		// Compare the next run of digits or letters
+		var segA, segB string
+		numeric := isDigit(a[0])
+		if numeric {
+			segA, a = splitRun(a, isDigit)
+			segB, b = splitRun(b, isDigit)
+		} else {
+			segA, a = splitRun(a, isLetter)
+			segB, b = splitRun(b, isLetter)
+		}
+
+		// A numeric run is newer than an alphabetic one
+		if segB == "" {
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+
+		if numeric {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) > len(segB) {
+					return 1
+				}
+				return -1
+			}
+		}
+
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+
+	// The string with remaining runs is newer
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+// splitRun splits s after the leading run of bytes matching fn
+func splitRun(s string, fn func(byte) bool) (string, string) {
+	i := 0
+	for i < len(s) && fn(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isVersionSeparator reports whether r only separates version runs
+func isVersionSeparator(r rune) bool {
+	return r >= 128 || (!isDigit(byte(r)) && !isLetter(byte(r)) && r != '~' && r != '^')
+}
@@ -0,0 +1,198 @@
+package entry
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EntrySource discovers boot entries stored in one configuration format
+type EntrySource interface {
+	// Name returns the short name used to enable or disable the source
+	Name() string
+
+	// Discover returns the entries found under dir, in menu order,
+	// searching no further than limits allow
+	Discover(dir string, limits WalkLimits) ([]*BootEntry, error)
+}
+
+// WalkLimits bound the search of a directory for entry files. The zero
+// value searches the whole tree.
+type WalkLimits struct {
+	MaxDepth int             // Deepest directory level searched, 0 for no limit
+	SkipDirs map[string]bool // Names of directories never searched
+}
+
+// sources holds the registered entry sources in menu order
+var sources []EntrySource
+
+func init() {
+	RegisterSource(blsSource{})
+	RegisterSource(grubSource{})
+	RegisterSource(extlinuxSource{})
+	RegisterSource(androidSource{})
+}
+
+// RegisterSource adds an entry source after the already registered ones
+func RegisterSource(source EntrySource) {
+	sources = append(sources, source)
+}
+
+// Sources returns all registered entry sources
+func Sources() []EntrySource {
+	return append([]EntrySource(nil), sources...)
+}
+
+// LookupSource returns the registered entry source with the given name
+func LookupSource(name string) (EntrySource, error) {
+	for _, source := range sources {
+		if source.Name() == name {
+			return source, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown entry source %q", name)
+}
+
+// FindEntriesFrom searches a directory with the given sources. Entries are
+// grouped by source and each entry records the source that produced it.
+func FindEntriesFrom(dir string, sources []EntrySource) ([]*BootEntry, error) {
+	return FindEntriesWithin(dir, sources, WalkLimits{})
+}
+
+// FindEntriesWithin searches a directory with the given sources like
+// FindEntriesFrom, within the given limits
+func FindEntriesWithin(dir string, sources []EntrySource, limits WalkLimits) ([]*BootEntry, error) {
+	// Check if directory exists
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("directory %s does not exist", dir)
+	}
+
+	var entries []*BootEntry
+	for _, source := range sources {
+		found, err := source.Discover(dir, limits)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning directory %s for %s entries: %v", dir, source.Name(), err)
+		}
+
+		for _, e := range found {
+			e.Source = source.Name()
+		}
+		entries = append(entries, found...)
+	}
+
+	return entries, nil
+}
+
+// walkEntryFiles calls parse for every file under dir, within limits,
+// accepted by match. Files that fail to parse are reported and skipped.
+func walkEntryFiles(dir string, limits WalkLimits, match func(path string, info os.FileInfo) bool, parse func(path string) ([]*BootEntry, error)) ([]*BootEntry, error) {
+	var entries []*BootEntry
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if path == dir {
+				return nil
+			}
+			rel, _ := filepath.Rel(dir, path)
+			depth := strings.Count(rel, string(filepath.Separator)) + 1
+			if limits.SkipDirs[info.Name()] || (limits.MaxDepth > 0 && depth > limits.MaxDepth) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Skip files of other formats
+		if !match(path, info) {
+			return nil
+		}
+
+		found, parseErr := parse(path)
+		if parseErr != nil {
+			// Log warning but continue processing other files
+			fmt.Fprintf(os.Stderr, "Warning: failed to parse %s: %v\n", path, parseErr)
+			return nil
+		}
+		entries = append(entries, found...)
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// blsSource finds Boot Loader Specification Type #1 entry files and
+// Type #2 unified kernel images, sorted together as the specification says
+type blsSource struct{}
+
+func (blsSource) Name() string {
+	return "bls"
+}
+
+func (blsSource) Discover(dir string, limits WalkLimits) ([]*BootEntry, error) {
+	entries, err := walkEntryFiles(dir, limits,
+		func(path string, info os.FileInfo) bool {
+			// extlinux.conf must be excluded from the generic .conf pattern
+			return isUKIFile(path) || (isEntryFile(info.Name()) && !isExtlinuxFile(info.Name()))
+		},
+		func(path string) ([]*BootEntry, error) {
+			var e *BootEntry
+			var err error
+			if isUKIFile(path) {
+				e, err = ParseUKI(path, dir)
+			} else {
+				e, err = ParseEntry(path)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return []*BootEntry{e}, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	SortEntries(entries)
+
+	return entries, nil
+}
+
+// grubSource finds menu entries in grub.cfg files
+type grubSource struct{}
+
+func (grubSource) Name() string {
+	return "grub"
+}
+
+func (grubSource) Discover(dir string, limits WalkLimits) ([]*BootEntry, error) {
+	return walkEntryFiles(dir, limits,
+		func(path string, info os.FileInfo) bool {
+			return info.Name() == "grub.cfg"
+		},
+		ParseGrubConfig)
+}
+
+// extlinuxSource finds labels in extlinux.conf and syslinux.cfg files
+type extlinuxSource struct{}
+
+func (extlinuxSource) Name() string {
+	return "extlinux"
+}
+
+func (extlinuxSource) Discover(dir string, limits WalkLimits) ([]*BootEntry, error) {
+	return walkEntryFiles(dir, limits,
+		func(path string, info os.FileInfo) bool {
+			return isExtlinuxFile(info.Name())
+		},
+		func(path string) ([]*BootEntry, error) {
+			config, err := ParseExtlinuxConfig(path, dir)
+			if err != nil {
+				return nil, err
+			}
+			return config.Entries, nil
+		})
+}
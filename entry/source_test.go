@@ -0,0 +1,45 @@
+package entry
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFindEntriesLimits(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"loader/entries/top.conf",
+		"root/entries/root.conf",
+		"a/b/c/d/depth4.conf",
+		"a/b/c/d/e/depth5.conf",
+	} {
+		id := filepath.Base(name)
+		writeFile(t, filepath.Join(dir, name), []byte("title "+id+"\nlinux /vmlinuz\n"))
+	}
+
+	limits := WalkLimits{MaxDepth: 4, SkipDirs: map[string]bool{"root": true}}
+	tests := []struct {
+		name   string
+		limits WalkLimits
+		want   []string
+	}{
+		{"unbounded", WalkLimits{}, []string{"depth4.conf", "depth5.conf", "root.conf", "top.conf"}},
+		{"limited", limits, []string{"depth4.conf", "top.conf"}},
+	}
+
+	for _, tt := range tests {
+		entries, err := FindEntriesWithin(dir, []EntrySource{blsSource{}}, tt.limits)
+		if err != nil {
+			t.Fatalf("%s: FindEntriesWithin() error: %v", tt.name, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Title)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: found %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
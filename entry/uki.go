@@ -0,0 +1,161 @@
+package entry
+
+import (
+	"bufio"
+	"bytes"
+	"debug/pe"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// UKI is an open Unified Kernel Image (Boot Loader Specification Type #2)
+type UKI struct {
+	file *pe.File
+}
+
+// peMachines maps PE machine types to BLS architecture identifiers
+var peMachines = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_I386:        "ia32",
+	pe.IMAGE_FILE_MACHINE_AMD64:       "x64",
+	pe.IMAGE_FILE_MACHINE_ARMNT:       "arm",
+	pe.IMAGE_FILE_MACHINE_ARM64:       "aa64",
+	pe.IMAGE_FILE_MACHINE_RISCV64:     "riscv64",
+	pe.IMAGE_FILE_MACHINE_LOONGARCH64: "loongarch64",
+}
+
+// isUKIFile checks if a path is a unified kernel image under EFI/Linux
+func isUKIFile(filePath string) bool {
+	return strings.EqualFold(filepath.Ext(filePath), ".efi") &&
+		strings.EqualFold(filepath.Base(filepath.Dir(filePath)), "Linux")
+}
+
+// OpenUKI opens a unified kernel image and checks it embeds a kernel
+func OpenUKI(filePath string) (*UKI, error) {
+	file, err := pe.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Section(".linux") == nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: no .linux section, not a unified kernel image", filePath)
+	}
+
+	return &UKI{file: file}, nil
+}
+
+// Close closes the image file
+func (u *UKI) Close() error {
+	return u.file.Close()
+}
+
+// Section returns the contents of a PE section, or nil if it does not exist
+func (u *UKI) Section(name string) ([]byte, error) {
+	section := u.file.Section(name)
+	if section == nil {
+		return nil, nil
+	}
+
+	data, err := section.Data()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read section %s: %v", name, err)
+	}
+
+	// The raw data is padded to the file alignment
+	if section.VirtualSize != 0 && int(section.VirtualSize) < len(data) {
+		data = data[:section.VirtualSize]
+	}
+
+	return data, nil
+}
+
+// Architecture returns the BLS architecture identifier of the image
+func (u *UKI) Architecture() string {
+	return peMachines[u.file.Machine]
+}
+
+// ParseUKI builds a boot entry from a unified kernel image. The entry's Efi
+// path is made relative to baseDir, the root of the boot partition.
+func ParseUKI(filePath, baseDir string) (*BootEntry, error) {
+	uki, err := OpenUKI(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer uki.Close()
+
+	osrel, err := uki.Section(".osrel")
+	if err != nil {
+		return nil, err
+	}
+	cmdline, err := uki.Section(".cmdline")
+	if err != nil {
+		return nil, err
+	}
+	uname, err := uki.Section(".uname")
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := filepath.Rel(baseDir, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	release := parseOSRelease(osrel)
+	e := &BootEntry{
+		Title:        firstNonEmpty(release["PRETTY_NAME"], release["NAME"], filepath.Base(filePath)),
+		Version:      firstNonEmpty(trimSection(uname), release["IMAGE_VERSION"], release["VERSION_ID"]),
+		SortKey:      firstNonEmpty(release["IMAGE_ID"], release["ID"]),
+		Efi:          path.Join("/", filepath.ToSlash(rel)),
+		Options:      trimSection(cmdline),
+		Architecture: uki.Architecture(),
+		FilePath:     filePath,
+	}
+	e.setBootCounter()
+
+	return e, nil
+}
+
+// parseOSRelease parses os-release(5) data into a map
+func parseOSRelease(data []byte) map[string]string {
+	release := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `"'`)
+		}
+		release[key] = value
+	}
+
+	return release
+}
+
+// trimSection strips NUL padding and whitespace from a text section
+func trimSection(data []byte) string {
+	return strings.TrimSpace(string(bytes.TrimRight(data, "\x00")))
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+package entry
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const androidVendorBootMagic = "VNDRBOOT"
+
+// Vendor ramdisk types from the vendor_boot v4 ramdisk table
+const (
+	VendorRamdiskNone     = 0
+	VendorRamdiskPlatform = 1
+	VendorRamdiskRecovery = 2
+	VendorRamdiskDLKM     = 3
+)
+
+// VendorRamdisk is one fragment of the vendor ramdisk section
+type VendorRamdisk struct {
+	Name    string
+	Type    uint32
+	Section ImageSection
+}
+
+// VendorBootImage is a parsed Android vendor_boot.img header, versions 3 and 4
+type VendorBootImage struct {
+	Path          string
+	HeaderVersion uint32
+	PageSize      uint32
+	Name          string
+	Cmdline       string
+	Ramdisk       ImageSection // All vendor ramdisk fragments
+	DTB           ImageSection
+	Bootconfig    ImageSection
+	Ramdisks      []VendorRamdisk // Fragments from the v4 ramdisk table
+}
+
+// AndroidImages names the images that together make up one Android boot.
+// InitBoot and VendorBoot are empty on devices that do not use them.
+type AndroidImages struct {
+	Boot       string
+	InitBoot   string
+	VendorBoot string
+}
+
+// OpenVendorBootImage reads and validates the header of an Android
+// vendor_boot image file or partition
+func OpenVendorBootImage(imagePath string) (*VendorBootImage, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hdr := make([]byte, 2128) // Size of the version 4 header
+	n, err := io.ReadFull(file, hdr)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	hdr = hdr[:n]
+
+	if len(hdr) < 2112 || string(hdr[:8]) != androidVendorBootMagic {
+		return nil, fmt.Errorf("%s: not an Android vendor_boot image", imagePath)
+	}
+
+	u32 := func(off int) uint32 { return binary.LittleEndian.Uint32(hdr[off:]) }
+	img := &VendorBootImage{
+		Path:          imagePath,
+		HeaderVersion: u32(8),
+		PageSize:      u32(12),
+		Cmdline:       cString(hdr[28 : 28+2048]),
+		Name:          cString(hdr[2080:2096]),
+	}
+	if img.PageSize == 0 {
+		return nil, fmt.Errorf("%s: invalid page size", imagePath)
+	}
+
+	page := int64(img.PageSize)
+	headerSize := int64(u32(2096))
+	img.Ramdisk = ImageSection{Offset: alignUp(headerSize, page), Size: int64(u32(24))}
+	img.DTB = ImageSection{Offset: img.Ramdisk.Offset + alignUp(img.Ramdisk.Size, page), Size: int64(u32(2100))}
+
+	if img.HeaderVersion < 4 || len(hdr) < 2128 {
+		return img, nil
+	}
+
+	// Version 4 adds the ramdisk table and bootconfig after the DTB
+	table := ImageSection{Offset: img.DTB.Offset + alignUp(img.DTB.Size, page), Size: int64(u32(2112))}
+	entryNum, entrySize := u32(2116), u32(2120)
+	img.Bootconfig = ImageSection{Offset: table.Offset + alignUp(table.Size, page), Size: int64(u32(2124))}
+
+	if entryNum > 0 {
+		if entrySize < 44 || int64(entryNum)*int64(entrySize) > table.Size {
+			return nil, fmt.Errorf("%s: invalid vendor ramdisk table", imagePath)
+		}
+
+		data, err := readImageSection(imagePath, table)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := uint32(0); i < entryNum; i++ {
+			e := data[i*entrySize:]
+			size, offset := binary.LittleEndian.Uint32(e[0:]), binary.LittleEndian.Uint32(e[4:])
+			img.Ramdisks = append(img.Ramdisks, VendorRamdisk{
+				Type: binary.LittleEndian.Uint32(e[8:]),
+				Name: cString(e[12:44]),
+				Section: ImageSection{
+					Offset: img.Ramdisk.Offset + int64(offset),
+					Size:   int64(size),
+				},
+			})
+		}
+	}
+
+	return img, nil
+}
+
+// BootRamdisks returns the vendor ramdisk fragments loaded for a normal
+// boot, in table order. Recovery fragments are left out. Images without a
+// ramdisk table return the whole vendor ramdisk.
+func (img *VendorBootImage) BootRamdisks() []ImageSection {
+	if len(img.Ramdisks) == 0 {
+		if img.Ramdisk.Size == 0 {
+			return nil
+		}
+		return []ImageSection{img.Ramdisk}
+	}
+
+	var sections []ImageSection
+	for _, r := range img.Ramdisks {
+		if r.Type != VendorRamdiskRecovery {
+			sections = append(sections, r.Section)
+		}
+	}
+	return sections
+}
+
+// ReadSection reads a payload of the image. Empty sections return nil.
+func (img *VendorBootImage) ReadSection(s ImageSection) ([]byte, error) {
+	return readImageSection(img.Path, s)
+}
+
+// FindAndroidCompanions looks next to a boot image file for the init_boot
+// and vendor_boot images of the same slot, e.g. init_boot_a.img and
+// vendor_boot_a.img for boot_a.img
+func FindAndroidCompanions(bootPath string) AndroidImages {
+	images := AndroidImages{Boot: bootPath}
+
+	dir, base := filepath.Split(bootPath)
+	suffix, ok := strings.CutPrefix(base, "boot")
+	if !ok {
+		return images
+	}
+
+	if initBoot := filepath.Join(dir, "init_boot"+suffix); hasMagic(initBoot, androidBootMagic) {
+		images.InitBoot = initBoot
+	}
+	if vendorBoot := filepath.Join(dir, "vendor_boot"+suffix); hasMagic(vendorBoot, androidVendorBootMagic) {
+		images.VendorBoot = vendorBoot
+	}
+
+	return images
+}
+
+// IsVendorBootImage checks if a file or partition holds a vendor_boot image
+func IsVendorBootImage(imagePath string) bool {
+	return hasMagic(imagePath, androidVendorBootMagic)
+}
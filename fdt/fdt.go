@@ -0,0 +1,443 @@
+package fdt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Flattened device tree format constants
+const (
+	magic = 0xd00dfeed
+
+	tokenBeginNode = 0x1
+	tokenEndNode   = 0x2
+	tokenProp      = 0x3
+	tokenNop       = 0x4
+	tokenEnd       = 0x9
+
+	headerSize = 40
+
+	// Version written by Marshal, readable by any version 16 parser
+	version           = 17
+	lastCompatVersion = 16
+)
+
+// MemReserve is an entry of the memory reservation block
+type MemReserve struct {
+	Address uint64
+	Size    uint64
+}
+
+// Property is a named property value
+type Property struct {
+	Name  string
+	Value []byte
+}
+
+// Node is a device tree node
+type Node struct {
+	Name       string // Name with unit address, e.g. "memory@80000000", empty for the root
+	Properties []*Property
+	Children   []*Node
+}
+
+// Tree is a parsed flattened device tree blob
+type Tree struct {
+	Root       *Node
+	BootCPUID  uint32
+	MemReserve []MemReserve
+}
+
+// Parse reads a flattened device tree blob
+func Parse(data []byte) (*Tree, error) {
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("device tree too short")
+	}
+
+	be32 := func(off int) uint32 { return binary.BigEndian.Uint32(data[off:]) }
+	if be32(0) != magic {
+		return nil, fmt.Errorf("bad device tree magic %#x", be32(0))
+	}
+
+	totalSize := int(be32(4))
+	structOff, stringsOff, rsvOff := int(be32(8)), int(be32(12)), int(be32(16))
+	if be32(20) < lastCompatVersion {
+		return nil, fmt.Errorf("unsupported device tree version %d", be32(20))
+	}
+	if totalSize < headerSize || totalSize > len(data) {
+		return nil, fmt.Errorf("device tree truncated")
+	}
+	for _, off := range []int{rsvOff, structOff, stringsOff} {
+		if off < headerSize || off > totalSize {
+			return nil, fmt.Errorf("device tree block offset %#x out of range", off)
+		}
+	}
+	data = data[:totalSize]
+
+	stringsEnd := totalSize
+	if stringsSize := int(be32(32)); stringsSize >= 0 && stringsSize <= totalSize-stringsOff {
+		stringsEnd = stringsOff + stringsSize
+	}
+	// The strings block follows the structure block, which version 16
+	// blobs give no size
+	if structOff > stringsOff {
+		return nil, fmt.Errorf("device tree strings block before the structure block")
+	}
+	structEnd := stringsOff
+	if structSize := int(be32(36)); structSize > 0 && structSize <= stringsOff-structOff {
+		structEnd = structOff + structSize
+	}
+
+	tree := &Tree{BootCPUID: be32(28)}
+
+	// Memory reservations end with an all-zero entry
+	for off := rsvOff; ; off += 16 {
+		if off+16 > totalSize {
+			return nil, fmt.Errorf("unterminated memory reservation block")
+		}
+		r := MemReserve{
+			Address: binary.BigEndian.Uint64(data[off:]),
+			Size:    binary.BigEndian.Uint64(data[off+8:]),
+		}
+		if r.Address == 0 && r.Size == 0 {
+			break
+		}
+		tree.MemReserve = append(tree.MemReserve, r)
+	}
+
+	p := &parser{data: data[:structEnd], off: structOff, strings: data[stringsOff:stringsEnd]}
+	root, err := p.parseStruct()
+	if err != nil {
+		return nil, err
+	}
+	tree.Root = root
+	return tree, nil
+}
+
+// parser walks the structure block
+type parser struct {
+	data    []byte
+	off     int
+	strings []byte
+}
+
+// token reads the next token, skipping NOPs
+func (p *parser) token() (uint32, error) {
+	for {
+		if p.off+4 > len(p.data) {
+			return 0, fmt.Errorf("structure block truncated at %#x", p.off)
+		}
+		t := binary.BigEndian.Uint32(p.data[p.off:])
+		p.off += 4
+		if t != tokenNop {
+			return t, nil
+		}
+	}
+}
+
+// parseStruct parses the structure block into the root node
+func (p *parser) parseStruct() (*Node, error) {
+	t, err := p.token()
+	if err != nil {
+		return nil, err
+	}
+	if t != tokenBeginNode {
+		return nil, fmt.Errorf("structure block does not start with a node")
+	}
+
+	root, err := p.parseNode()
+	if err != nil {
+		return nil, err
+	}
+
+	if t, err = p.token(); err != nil {
+		return nil, err
+	}
+	if t != tokenEnd {
+		return nil, fmt.Errorf("unexpected token %#x after root node", t)
+	}
+	return root, nil
+}
+
+// parseNode parses a node after its BEGIN_NODE token
+func (p *parser) parseNode() (*Node, error) {
+	end := bytes.IndexByte(p.data[p.off:], 0)
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated node name at %#x", p.off)
+	}
+	node := &Node{Name: string(p.data[p.off : p.off+end])}
+	p.off = align4(p.off + end + 1)
+
+	for {
+		t, err := p.token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case tokenProp:
+			if p.off+8 > len(p.data) {
+				return nil, fmt.Errorf("property truncated at %#x", p.off)
+			}
+			size := int(binary.BigEndian.Uint32(p.data[p.off:]))
+			nameOff := int(binary.BigEndian.Uint32(p.data[p.off+4:]))
+			p.off += 8
+			if size < 0 || size > len(p.data)-p.off {
+				return nil, fmt.Errorf("property value truncated at %#x", p.off)
+			}
+			if nameOff < 0 || nameOff >= len(p.strings) {
+				return nil, fmt.Errorf("property name offset %#x out of range", nameOff)
+			}
+			node.Properties = append(node.Properties, &Property{
+				Name:  cString(p.strings[nameOff:]),
+				Value: append([]byte{}, p.data[p.off:p.off+size]...),
+			})
+			p.off = align4(p.off + size)
+
+		case tokenBeginNode:
+			child, err := p.parseNode()
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+
+		case tokenEndNode:
+			return node, nil
+
+		default:
+			return nil, fmt.Errorf("unexpected token %#x in node %q", t, node.Name)
+		}
+	}
+}
+
+// Marshal writes the tree as a flattened device tree blob
+func (t *Tree) Marshal() []byte {
+	w := &writer{stringOffsets: map[string]int{}}
+	w.writeNode(t.Root)
+	w.u32(tokenEnd)
+
+	var rsv bytes.Buffer
+	for _, r := range t.MemReserve {
+		binary.Write(&rsv, binary.BigEndian, r)
+	}
+	binary.Write(&rsv, binary.BigEndian, MemReserve{})
+
+	rsvOff := align8(headerSize)
+	structOff := rsvOff + rsv.Len()
+	stringsOff := structOff + w.structs.Len()
+	totalSize := stringsOff + w.strings.Len()
+
+	out := make([]byte, rsvOff, totalSize)
+	header := []uint32{
+		magic,
+		uint32(totalSize),
+		uint32(structOff),
+		uint32(stringsOff),
+		uint32(rsvOff),
+		version,
+		lastCompatVersion,
+		t.BootCPUID,
+		uint32(w.strings.Len()),
+		uint32(w.structs.Len()),
+	}
+	for i, v := range header {
+		binary.BigEndian.PutUint32(out[i*4:], v)
+	}
+
+	out = append(out, rsv.Bytes()...)
+	out = append(out, w.structs.Bytes()...)
+	return append(out, w.strings.Bytes()...)
+}
+
+// writer builds the structure and strings blocks
+type writer struct {
+	structs       bytes.Buffer
+	strings       bytes.Buffer
+	stringOffsets map[string]int
+}
+
+func (w *writer) u32(v uint32) {
+	binary.Write(&w.structs, binary.BigEndian, v)
+}
+
+// pad aligns the structure block to 4 bytes
+func (w *writer) pad() {
+	for w.structs.Len()%4 != 0 {
+		w.structs.WriteByte(0)
+	}
+}
+
+// stringOffset returns the offset of name in the strings block, adding it once
+func (w *writer) stringOffset(name string) int {
+	if off, ok := w.stringOffsets[name]; ok {
+		return off
+	}
+	off := w.strings.Len()
+	w.strings.WriteString(name)
+	w.strings.WriteByte(0)
+	w.stringOffsets[name] = off
+	return off
+}
+
+func (w *writer) writeNode(n *Node) {
+	w.u32(tokenBeginNode)
+	w.structs.WriteString(n.Name)
+	w.structs.WriteByte(0)
+	w.pad()
+
+	for _, prop := range n.Properties {
+		w.u32(tokenProp)
+		w.u32(uint32(len(prop.Value)))
+		w.u32(uint32(w.stringOffset(prop.Name)))
+		w.structs.Write(prop.Value)
+		w.pad()
+	}
+	for _, child := range n.Children {
+		w.writeNode(child)
+	}
+
+	w.u32(tokenEndNode)
+}
+
+// Property returns the property with the given name, nil if there is none
+func (n *Node) Property(name string) *Property {
+	for _, prop := range n.Properties {
+		if prop.Name == name {
+			return prop
+		}
+	}
+	return nil
+}
+
+// SetProperty sets a property value, replacing an existing one
+func (n *Node) SetProperty(name string, value []byte) {
+	if prop := n.Property(name); prop != nil {
+		prop.Value = value
+		return
+	}
+	n.Properties = append(n.Properties, &Property{Name: name, Value: value})
+}
+
+// DeleteProperty removes a property if present
+func (n *Node) DeleteProperty(name string) {
+	for i, prop := range n.Properties {
+		if prop.Name == name {
+			n.Properties = append(n.Properties[:i], n.Properties[i+1:]...)
+			return
+		}
+	}
+}
+
+// Child returns the child node with the given name, nil if there is none.
+// A name without unit address also matches a child with one if that is
+// the only match, as in device tree paths.
+func (n *Node) Child(name string) *Node {
+	var match *Node
+	for _, child := range n.Children {
+		if child.Name == name {
+			return child
+		}
+		if !strings.Contains(name, "@") && strings.HasPrefix(child.Name, name+"@") {
+			if match != nil {
+				return nil // Ambiguous
+			}
+			match = child
+		}
+	}
+	return match
+}
+
+// AddChild returns the child node with the given name, creating it if needed
+func (n *Node) AddChild(name string) *Node {
+	for _, child := range n.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	child := &Node{Name: name}
+	n.Children = append(n.Children, child)
+	return child
+}
+
+// Lookup finds a node by absolute path, e.g. "/soc/i2c@1000"
+func (t *Tree) Lookup(path string) *Node {
+	if !strings.HasPrefix(path, "/") {
+		return nil
+	}
+
+	node := t.Root
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		if node = node.Child(name); node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// Walk calls fn for every node with its absolute path, parents first
+func (t *Tree) Walk(fn func(path string, n *Node) error) error {
+	return walk("/", t.Root, fn)
+}
+
+func walk(path string, n *Node, fn func(string, *Node) error) error {
+	if err := fn(path, n); err != nil {
+		return err
+	}
+	for _, child := range n.Children {
+		if err := walk(joinPath(path, child.Name), child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinPath appends a node name to a node path
+func joinPath(path, name string) string {
+	if path == "/" {
+		return "/" + name
+	}
+	return path + "/" + name
+}
+
+// Uint32 returns the first cell of a property value
+func (p *Property) Uint32() (uint32, bool) {
+	if len(p.Value) < 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(p.Value), true
+}
+
+// String returns the value of a string property
+func (p *Property) String() string {
+	return cString(p.Value)
+}
+
+// Strings returns the values of a string list property
+func (p *Property) Strings() []string {
+	value := strings.TrimSuffix(string(p.Value), "\x00")
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, "\x00")
+}
+
+// cString returns the NUL-terminated string at the start of b
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
+
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
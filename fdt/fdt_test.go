@@ -0,0 +1,145 @@
+package fdt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// parseFixture parses a blob of testdata, laid out as dtc -@ compiles the
+// .dts source next to it
+func parseFixture(t *testing.T, name string) (*Tree, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse(%s) error: %v", name, err)
+	}
+	return tree, data
+}
+
+// lookup returns the node at path, failing the test if it is missing
+func lookup(t *testing.T, tree *Tree, path string) *Node {
+	t.Helper()
+	node := tree.Lookup(path)
+	if node == nil {
+		t.Fatalf("node %s not found", path)
+	}
+	return node
+}
+
+func TestParse(t *testing.T) {
+	tree, _ := parseFixture(t, "base.dtb")
+
+	if got := tree.Root.Property("model").String(); got != "Test Board" {
+		t.Errorf("model = %q, want %q", got, "Test Board")
+	}
+	if want := []MemReserve{{0x80000000, 0x10000}}; !reflect.DeepEqual(tree.MemReserve, want) {
+		t.Errorf("MemReserve = %v, want %v", tree.MemReserve, want)
+	}
+
+	// Names without unit address match a single child
+	uart := lookup(t, tree, "/soc/serial")
+	if got := uart.Property("compatible").Strings(); !reflect.DeepEqual(got, []string{"test,uart"}) {
+		t.Errorf("uart compatible = %q", got)
+	}
+	if got := uart.Property("reg").Value; !bytes.Equal(got, []byte{0, 0, 0x10, 0, 0, 0, 1, 0}) {
+		t.Errorf("uart reg = %x", got)
+	}
+	if got := lookup(t, tree, "/interrupt-controller").Property("interrupt-controller").Value; len(got) != 0 {
+		t.Errorf("empty property has value %x", got)
+	}
+	if tree.Lookup("/soc/spi") != nil || tree.Lookup("soc") != nil {
+		t.Errorf("Lookup found a missing or relative path")
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, name := range []string{"base.dtb", "overlay.dtbo"} {
+		t.Run(name, func(t *testing.T) {
+			tree, _ := parseFixture(t, name)
+
+			blob := tree.Marshal()
+			again, err := Parse(blob)
+			if err != nil {
+				t.Fatalf("Parse(Marshal()) error: %v", err)
+			}
+			if !reflect.DeepEqual(again, tree) {
+				t.Errorf("Parse(Marshal()) differs from the parsed tree")
+			}
+			if !bytes.Equal(again.Marshal(), blob) {
+				t.Errorf("Marshal() of the same tree differs")
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	_, data := parseFixture(t, "base.dtb")
+
+	// withHeader returns the blob with a header field changed
+	withHeader := func(off int, value uint32) []byte {
+		blob := bytes.Clone(data)
+		binary.BigEndian.PutUint32(blob[off:], value)
+		return blob
+	}
+	tiny := make([]byte, 64)
+	binary.BigEndian.PutUint32(tiny[0:], magic)
+	binary.BigEndian.PutUint32(tiny[4:], 8)
+	binary.BigEndian.PutUint32(tiny[20:], version)
+	structOff := binary.BigEndian.Uint32(data[8:])
+	stringsOff := binary.BigEndian.Uint32(data[12:])
+
+	tests := map[string][]byte{
+		"short":             data[:headerSize-1],
+		"tiny totalsize":    tiny,
+		"truncated":         data[:len(data)-1],
+		"bad magic":         withHeader(0, 0),
+		"old version":       withHeader(20, 15),
+		"bad string":        withHeader(32, 4), // Strings block cut short
+		"small totalsize":   withHeader(4, 8),
+		"struct in header":  withHeader(8, 8),
+		"strings in header": withHeader(12, 0),
+		"reserve in header": withHeader(16, 16),
+		"struct past end":   withHeader(8, uint32(len(data))+4),
+		"strings before":    withHeader(12, structOff-8),
+		"struct after":      withHeader(8, stringsOff+4),
+	}
+	for name, blob := range tests {
+		if _, err := Parse(blob); err == nil {
+			t.Errorf("Parse(%s) succeeded", name)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, name := range []string{"base.dtb", "overlay.dtbo"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tree, err := Parse(data)
+		if err != nil {
+			return
+		}
+
+		// Whatever parses must survive a round trip
+		again, err := Parse(tree.Marshal())
+		if err != nil {
+			t.Fatalf("Parse(Marshal()) error: %v", err)
+		}
+		if !reflect.DeepEqual(again, tree) {
+			t.Errorf("Parse(Marshal()) differs from the parsed tree")
+		}
+	})
+}
@@ -0,0 +1,317 @@
+package fdt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Special nodes of overlays compiled with dtc -@
+const (
+	fixupsNode      = "__fixups__"
+	localFixupsNode = "__local_fixups__"
+	symbolsNode     = "__symbols__"
+	overlayNode     = "__overlay__"
+)
+
+// ApplyOverlay merges a compiled overlay (.dtbo) into the base tree. The
+// overlay's phandles are renumbered above those of the base, references
+// to base labels listed in __fixups__ are resolved through the base
+// __symbols__, each fragment's __overlay__ node is merged into its target,
+// and the overlay's own labels are added to the base __symbols__.
+// The overlay tree is modified. On error the base may be partially merged.
+func ApplyOverlay(base, overlay *Tree) error {
+	delta := maxPhandle(base.Root)
+	if err := renumberPhandles(overlay, delta); err != nil {
+		return err
+	}
+	if err := resolveFixups(base, overlay); err != nil {
+		return err
+	}
+
+	targets := map[string]string{} // Fragment name to target path
+	for _, fragment := range overlay.Root.Children {
+		content := fragment.Child(overlayNode)
+		if content == nil {
+			continue // Not a fragment, e.g. __fixups__
+		}
+
+		target, err := fragmentTarget(base, fragment)
+		if err != nil {
+			return fmt.Errorf("fragment %s: %v", fragment.Name, err)
+		}
+		mergeNode(target, content)
+		targets[fragment.Name] = base.pathOf(target)
+	}
+
+	return mergeSymbols(base, overlay, targets)
+}
+
+// phandle returns the phandle of a node, zero if it has none
+func phandle(n *Node) uint32 {
+	for _, name := range []string{"phandle", "linux,phandle"} {
+		if prop := n.Property(name); prop != nil {
+			if v, ok := prop.Uint32(); ok {
+				return v
+			}
+		}
+	}
+	return 0
+}
+
+// maxPhandle returns the largest phandle below n
+func maxPhandle(n *Node) uint32 {
+	highest := phandle(n)
+	if highest == 0xffffffff {
+		highest = 0
+	}
+	for _, child := range n.Children {
+		highest = max(highest, maxPhandle(child))
+	}
+	return highest
+}
+
+// renumberPhandles adds delta to every phandle defined in the overlay and to
+// every reference to one, listed in __local_fixups__
+func renumberPhandles(overlay *Tree, delta uint32) error {
+	if delta == 0 {
+		return nil
+	}
+
+	var adjust func(n *Node)
+	adjust = func(n *Node) {
+		for _, name := range []string{"phandle", "linux,phandle"} {
+			if prop := n.Property(name); prop != nil {
+				if v, ok := prop.Uint32(); ok && v != 0 && v != 0xffffffff {
+					binary.BigEndian.PutUint32(prop.Value, v+delta)
+				}
+			}
+		}
+		for _, child := range n.Children {
+			adjust(child)
+		}
+	}
+	adjust(overlay.Root)
+
+	fixups := overlay.Root.Child(localFixupsNode)
+	if fixups == nil {
+		return nil
+	}
+	return adjustLocalFixups(overlay.Root, fixups, "", delta)
+}
+
+// adjustLocalFixups walks __local_fixups__, which mirrors the overlay tree,
+// and adds delta to the phandle references at the listed offsets
+func adjustLocalFixups(n, fixups *Node, path string, delta uint32) error {
+	for _, fixup := range fixups.Properties {
+		prop := n.Property(fixup.Name)
+		if prop == nil {
+			return fmt.Errorf("local fixup for missing property %s/%s", path, fixup.Name)
+		}
+		for i := 0; i+4 <= len(fixup.Value); i += 4 {
+			off := int(binary.BigEndian.Uint32(fixup.Value[i:]))
+			if off+4 > len(prop.Value) {
+				return fmt.Errorf("local fixup offset %d out of range in %s/%s", off, path, fixup.Name)
+			}
+			v := binary.BigEndian.Uint32(prop.Value[off:])
+			binary.BigEndian.PutUint32(prop.Value[off:], v+delta)
+		}
+	}
+
+	for _, fixupChild := range fixups.Children {
+		child := n.Child(fixupChild.Name)
+		if child == nil {
+			return fmt.Errorf("local fixup for missing node %s/%s", path, fixupChild.Name)
+		}
+		if err := adjustLocalFixups(child, fixupChild, path+"/"+fixupChild.Name, delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveFixups patches references to labels of the base tree. Each
+// property of __fixups__ names a label and lists "path:property:offset"
+// locations in the overlay referring to it.
+func resolveFixups(base, overlay *Tree) error {
+	fixups := overlay.Root.Child(fixupsNode)
+	if fixups == nil {
+		return nil
+	}
+
+	symbols := base.Root.Child(symbolsNode)
+	if symbols == nil {
+		return fmt.Errorf("overlay references labels but the base has no __symbols__ (compile it with dtc -@)")
+	}
+
+	for _, fixup := range fixups.Properties {
+		symbol := symbols.Property(fixup.Name)
+		if symbol == nil {
+			return fmt.Errorf("label %s not found in base", fixup.Name)
+		}
+		target := base.Lookup(symbol.String())
+		if target == nil {
+			return fmt.Errorf("label %s points to missing node %s", fixup.Name, symbol.String())
+		}
+		value := ensurePhandle(target, base, overlay)
+
+		for _, location := range fixup.Strings() {
+			if err := patchLocation(overlay, location, value); err != nil {
+				return fmt.Errorf("label %s: %v", fixup.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// patchLocation writes a phandle at a "path:property:offset" location
+func patchLocation(overlay *Tree, location string, value uint32) error {
+	parts := strings.Split(location, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed fixup %q", location)
+	}
+
+	node := overlay.Lookup(parts[0])
+	if node == nil {
+		return fmt.Errorf("fixup node %s not found", parts[0])
+	}
+	prop := node.Property(parts[1])
+	if prop == nil {
+		return fmt.Errorf("fixup property %s:%s not found", parts[0], parts[1])
+	}
+	off, err := strconv.Atoi(parts[2])
+	if err != nil || off < 0 || off+4 > len(prop.Value) {
+		return fmt.Errorf("fixup offset %s out of range in %s:%s", parts[2], parts[0], parts[1])
+	}
+
+	binary.BigEndian.PutUint32(prop.Value[off:], value)
+	return nil
+}
+
+// ensurePhandle returns the phandle of a base node, assigning one above
+// those of both trees if the node has none
+func ensurePhandle(n *Node, base, overlay *Tree) uint32 {
+	if v := phandle(n); v != 0 && v != 0xffffffff {
+		return v
+	}
+	v := max(maxPhandle(base.Root), maxPhandle(overlay.Root)) + 1
+	n.SetProperty("phandle", cell(v))
+	return v
+}
+
+// fragmentTarget finds the base node a fragment applies to, given by a
+// target phandle or a target-path
+func fragmentTarget(base *Tree, fragment *Node) (*Node, error) {
+	if prop := fragment.Property("target"); prop != nil {
+		v, ok := prop.Uint32()
+		if !ok {
+			return nil, fmt.Errorf("invalid target")
+		}
+		if target := findPhandle(base.Root, v); target != nil {
+			return target, nil
+		}
+		return nil, fmt.Errorf("target phandle %#x not found in base", v)
+	}
+
+	if prop := fragment.Property("target-path"); prop != nil {
+		path := prop.String()
+		if target := base.resolvePath(path); target != nil {
+			return target, nil
+		}
+		return nil, fmt.Errorf("target path %s not found in base", path)
+	}
+
+	return nil, fmt.Errorf("no target or target-path")
+}
+
+// resolvePath looks up an absolute path or an alias, optionally followed by
+// a relative path
+func (t *Tree) resolvePath(path string) *Node {
+	if strings.HasPrefix(path, "/") {
+		return t.Lookup(path)
+	}
+
+	alias, rest, _ := strings.Cut(path, "/")
+	aliases := t.Lookup("/aliases")
+	if aliases == nil {
+		return nil
+	}
+	prop := aliases.Property(alias)
+	if prop == nil {
+		return nil
+	}
+	if rest != "" {
+		return t.Lookup(prop.String() + "/" + rest)
+	}
+	return t.Lookup(prop.String())
+}
+
+// findPhandle finds the node below n with the given phandle
+func findPhandle(n *Node, v uint32) *Node {
+	if phandle(n) == v {
+		return n
+	}
+	for _, child := range n.Children {
+		if found := findPhandle(child, v); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// mergeNode copies the properties and children of src into dst, replacing
+// properties that exist in both
+func mergeNode(dst, src *Node) {
+	for _, prop := range src.Properties {
+		dst.SetProperty(prop.Name, append([]byte{}, prop.Value...))
+	}
+	for _, child := range src.Children {
+		mergeNode(dst.AddChild(child.Name), child)
+	}
+}
+
+// mergeSymbols adds the overlay's labels to the base __symbols__, with
+// paths rewritten from the fragment to its target
+func mergeSymbols(base, overlay *Tree, targets map[string]string) error {
+	symbols := overlay.Root.Child(symbolsNode)
+	if symbols == nil {
+		return nil
+	}
+
+	baseSymbols := base.Root.AddChild(symbolsNode)
+	for _, symbol := range symbols.Properties {
+		path := symbol.String()
+
+		// Paths look like /fragment@0/__overlay__/node
+		fragment, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		rest, ok := strings.CutPrefix(rest, overlayNode)
+		target, found := targets[fragment]
+		if !ok || !found {
+			continue // Labels outside fragments have no meaning in the base
+		}
+
+		if target == "/" && rest != "" {
+			target = ""
+		}
+		baseSymbols.SetProperty(symbol.Name, append([]byte(target+rest), 0))
+	}
+	return nil
+}
+
+// pathOf returns the absolute path of a node of the tree
+func (t *Tree) pathOf(target *Node) string {
+	found := ""
+	t.Walk(func(path string, n *Node) error {
+		if n == target && found == "" {
+			found = path
+		}
+		return nil
+	})
+	return found
+}
+
+// cell encodes a single 32-bit cell
+func cell(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
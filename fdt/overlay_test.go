@@ -0,0 +1,111 @@
+package fdt
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// cells returns the 32-bit cells of a property value
+func cells(t *testing.T, n *Node, name string) []uint32 {
+	t.Helper()
+	prop := n.Property(name)
+	if prop == nil {
+		t.Fatalf("property %s missing", name)
+	}
+	var values []uint32
+	for i := 0; i+4 <= len(prop.Value); i += 4 {
+		values = append(values, binary.BigEndian.Uint32(prop.Value[i:]))
+	}
+	return values
+}
+
+func TestApplyOverlay(t *testing.T) {
+	base, _ := parseFixture(t, "base.dtb")
+	overlay, _ := parseFixture(t, "overlay.dtbo")
+
+	if err := ApplyOverlay(base, overlay); err != nil {
+		t.Fatalf("ApplyOverlay() error: %v", err)
+	}
+
+	// The merged tree must survive a round trip like any other
+	merged, err := Parse(base.Marshal())
+	if err != nil {
+		t.Fatalf("Parse(Marshal()) error: %v", err)
+	}
+
+	// Fragments apply to a target-path and to a target phandle from __fixups__
+	for _, path := range []string{"/soc/serial@1000", "/soc/i2c@2000"} {
+		if got := lookup(t, merged, path).Property("status").String(); got != "okay" {
+			t.Errorf("%s status = %q, want okay", path, got)
+		}
+	}
+
+	// Overlay phandles are renumbered above the base's highest, 3, and the
+	// references in __local_fixups__ follow them
+	expander := lookup(t, merged, "/soc/i2c@2000/gpio@20")
+	if got := cells(t, expander, "phandle"); !reflect.DeepEqual(got, []uint32{4}) {
+		t.Errorf("expander phandle = %v, want [4]", got)
+	}
+	sensor := lookup(t, merged, "/soc/i2c@2000/sensor@48")
+	if got := cells(t, sensor, "reset-gpios"); !reflect.DeepEqual(got, []uint32{4, 3, 0}) {
+		t.Errorf("sensor reset-gpios = %v, want [4 3 0]", got)
+	}
+
+	// References to base labels are resolved through __fixups__
+	if got := cells(t, sensor, "interrupt-parent"); !reflect.DeepEqual(got, []uint32{1}) {
+		t.Errorf("sensor interrupt-parent = %v, want [1]", got)
+	}
+
+	// Base properties outside the overlay are kept
+	if got := lookup(t, merged, "/soc/i2c@2000").Property("compatible").String(); got != "test,i2c" {
+		t.Errorf("i2c compatible = %q, want test,i2c", got)
+	}
+
+	// Overlay labels are added to the base symbols at their target
+	symbols := lookup(t, merged, "/__symbols__")
+	if got := symbols.Property("expander").String(); got != "/soc/i2c@2000/gpio@20" {
+		t.Errorf("expander symbol = %q", got)
+	}
+	if got := symbols.Property("intc").String(); got != "/interrupt-controller" {
+		t.Errorf("intc symbol = %q", got)
+	}
+
+	// Fragments and fixup nodes are not merged into the base
+	for _, name := range []string{"fragment@0", "__fixups__", "__local_fixups__", "__overlay__"} {
+		if merged.Root.Child(name) != nil || lookup(t, merged, "/soc/i2c@2000").Child(name) != nil {
+			t.Errorf("node %s merged into the base", name)
+		}
+	}
+}
+
+func TestApplyOverlayErrors(t *testing.T) {
+	tests := map[string]func(base, overlay *Tree){
+		"no base symbols": func(base, overlay *Tree) {
+			base.Root.Child(symbolsNode).Name = "symbols"
+		},
+		"unknown label": func(base, overlay *Tree) {
+			base.Root.Child(symbolsNode).DeleteProperty("i2c")
+		},
+		"bad fixup offset": func(base, overlay *Tree) {
+			overlay.Root.Child(fixupsNode).SetProperty("i2c", []byte("/fragment@1:target:4\x00"))
+		},
+		"bad local fixup": func(base, overlay *Tree) {
+			overlay.Lookup("/fragment@1/__overlay__/sensor@48").DeleteProperty("reset-gpios")
+		},
+		"missing target path": func(base, overlay *Tree) {
+			overlay.Lookup("/fragment@0").SetProperty("target-path", []byte("/soc/spi@3000\x00"))
+		},
+	}
+
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			base, _ := parseFixture(t, "base.dtb")
+			overlay, _ := parseFixture(t, "overlay.dtbo")
+			modify(base, overlay)
+			if err := ApplyOverlay(base, overlay); err == nil {
+				t.Errorf("ApplyOverlay() succeeded")
+			}
+		})
+	}
+}
@@ -13,6 +13,7 @@ import (
 type KeyEvent struct {
 	Code KeyCode
 	Type EventType
+	Rune rune // Character typed with the key, 0 for keys without one
 }
 
 // KeyCode represents different input keys
@@ -27,6 +28,17 @@ const (
 	KeyQuit            // Q key
 )
 
+// Editing keys, numbered after the number keys 1-9 that follow KeyQuit
+const (
+	KeyLeft KeyCode = KeyQuit + 10 + iota
+	KeyRight
+	KeyHome
+	KeyEnd
+	KeyBackspace
+	KeyDelete
+	KeyChar // Any other key typing a character, see KeyEvent.Rune
+)
+
 // EventType represents the type of key event
 type EventType int
 
@@ -48,6 +60,7 @@ type InputManager struct {
 	devices   []InputDevice
 	eventChan chan KeyEvent
 	stopChan  chan bool
+	keymap    map[uint16][2]rune // Characters typed by each key
 }
 
 // Linux input event structure
@@ -76,10 +89,19 @@ const (
 	KEY_7          = 8
 	KEY_8          = 9
 	KEY_9          = 10
+	KEY_0          = 11
+	KEY_BACKSPACE  = 14
 	KEY_Q          = 16
 	KEY_ENTER      = 28
+	KEY_LEFTSHIFT  = 42
+	KEY_RIGHTSHIFT = 54
+	KEY_HOME       = 102
 	KEY_UP         = 103
+	KEY_LEFT       = 105
+	KEY_RIGHT      = 106
+	KEY_END        = 107
 	KEY_DOWN       = 108
+	KEY_DELETE     = 111
 	KEY_VOLUMEDOWN = 114
 	KEY_VOLUMEUP   = 115
 	KEY_POWER      = 116
@@ -91,6 +113,7 @@ func NewInputManager() *InputManager {
 		devices:   make([]InputDevice, 0),
 		eventChan: make(chan KeyEvent, 10),
 		stopChan:  make(chan bool, 1),
+		keymap:    keymaps[DefaultKeymap],
 	}
 }
 
@@ -211,6 +234,13 @@ func (im *InputManager) handleKeyEvent(device *InputDevice, keyCode uint16, valu
 		if device.keyStates[keyCode] {
 			device.keyStates[keyCode] = false
 			keyEvent := im.translateKeyCode(keyCode)
+			if chars, ok := im.keymap[keyCode]; ok {
+				shift := device.keyStates[KEY_LEFTSHIFT] || device.keyStates[KEY_RIGHTSHIFT]
+				keyEvent.Rune = chars[0]
+				if shift {
+					keyEvent.Rune = chars[1]
+				}
+			}
 			if keyEvent.Code != KeyUnknown {
 				select {
 				case im.eventChan <- keyEvent:
@@ -244,8 +274,23 @@ func (im *InputManager) translateKeyCode(linuxCode uint16) KeyEvent {
 	case KEY_1, KEY_2, KEY_3, KEY_4, KEY_5, KEY_6, KEY_7, KEY_8, KEY_9:
 		// Map numbers to special key codes above KeyQuit
 		keyEvent.Code = KeyCode(int(KeyQuit) + int(linuxCode-KEY_1+1))
+	case KEY_LEFT:
+		keyEvent.Code = KeyLeft
+	case KEY_RIGHT:
+		keyEvent.Code = KeyRight
+	case KEY_HOME:
+		keyEvent.Code = KeyHome
+	case KEY_END:
+		keyEvent.Code = KeyEnd
+	case KEY_BACKSPACE:
+		keyEvent.Code = KeyBackspace
+	case KEY_DELETE:
+		keyEvent.Code = KeyDelete
 	default:
 		keyEvent.Code = KeyUnknown
+		if _, ok := im.keymap[linuxCode]; ok {
+			keyEvent.Code = KeyChar
+		}
 	}
 
 	return keyEvent
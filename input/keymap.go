@@ -0,0 +1,82 @@
+package input
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultKeymap is the keyboard layout used unless configured otherwise
+const DefaultKeymap = "us"
+
+// usKeymap maps Linux key codes of a US keyboard to the characters they
+// type without and with shift
+var usKeymap = map[uint16][2]rune{
+	2: {'1', '!'}, 3: {'2', '@'}, 4: {'3', '#'}, 5: {'4', '$'}, 6: {'5', '%'},
+	7: {'6', '^'}, 8: {'7', '&'}, 9: {'8', '*'}, 10: {'9', '('}, 11: {'0', ')'},
+	12: {'-', '_'}, 13: {'=', '+'},
+	16: {'q', 'Q'}, 17: {'w', 'W'}, 18: {'e', 'E'}, 19: {'r', 'R'}, 20: {'t', 'T'},
+	21: {'y', 'Y'}, 22: {'u', 'U'}, 23: {'i', 'I'}, 24: {'o', 'O'}, 25: {'p', 'P'},
+	26: {'[', '{'}, 27: {']', '}'},
+	30: {'a', 'A'}, 31: {'s', 'S'}, 32: {'d', 'D'}, 33: {'f', 'F'}, 34: {'g', 'G'},
+	35: {'h', 'H'}, 36: {'j', 'J'}, 37: {'k', 'K'}, 38: {'l', 'L'},
+	39: {';', ':'}, 40: {'\'', '"'}, 41: {'`', '~'}, 43: {'\\', '|'},
+	44: {'z', 'Z'}, 45: {'x', 'X'}, 46: {'c', 'C'}, 47: {'v', 'V'}, 48: {'b', 'B'},
+	49: {'n', 'N'}, 50: {'m', 'M'},
+	51: {',', '<'}, 52: {'.', '>'}, 53: {'/', '?'}, 57: {' ', ' '},
+}
+
+// deKeymap changes the keys of a German keyboard that differ from the US
+// layout. AltGr characters are not supported.
+var deKeymap = map[uint16][2]rune{
+	3: {'2', '"'}, 4: {'3', '§'}, 7: {'6', '&'}, 8: {'7', '/'}, 9: {'8', '('},
+	10: {'9', ')'}, 11: {'0', '='}, 12: {'ß', '?'}, 13: {'´', '`'},
+	21: {'z', 'Z'}, 26: {'ü', 'Ü'}, 27: {'+', '*'},
+	39: {'ö', 'Ö'}, 40: {'ä', 'Ä'}, 41: {'^', '°'}, 43: {'#', '\''},
+	44: {'y', 'Y'}, 51: {',', ';'}, 52: {'.', ':'}, 53: {'-', '_'}, 86: {'<', '>'},
+}
+
+// keymaps are the supported keyboard layouts by name
+var keymaps = map[string]map[uint16][2]rune{
+	"us": usKeymap,
+	"de": overlayKeymap(usKeymap, deKeymap),
+}
+
+// overlayKeymap returns base with the keys of changes replaced
+func overlayKeymap(base, changes map[uint16][2]rune) map[uint16][2]rune {
+	keymap := make(map[uint16][2]rune, len(base))
+	for code, chars := range base {
+		keymap[code] = chars
+	}
+	for code, chars := range changes {
+		keymap[code] = chars
+	}
+	return keymap
+}
+
+// Keymaps returns the names of the supported keyboard layouts
+func Keymaps() []string {
+	var names []string
+	for name := range keymaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckKeymap reports an error if a keyboard layout is not supported
+func CheckKeymap(name string) error {
+	if _, ok := keymaps[name]; !ok {
+		return fmt.Errorf("unknown keymap %q (known: %s)", name, strings.Join(Keymaps(), ", "))
+	}
+	return nil
+}
+
+// SetKeymap selects the keyboard layout used to type characters
+func (im *InputManager) SetKeymap(name string) error {
+	if err := CheckKeymap(name); err != nil {
+		return err
+	}
+	im.keymap = keymaps[name]
+	return nil
+}
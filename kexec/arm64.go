@@ -0,0 +1,130 @@
+package kexec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/timoxa0/kxmenu/fdt"
+)
+
+// arm64 Image header fields
+const (
+	arm64TextOffset = 0x08
+	arm64ImageSize  = 0x10
+	arm64Magic      = 0x38
+)
+
+// arm64KernelAlign is the alignment of the kernel's load base
+const arm64KernelAlign = 2 << 20
+
+// arm64Purgatory jumps to the kernel with x0 holding the devicetree
+// address and x1-x3 zero, as the arm64 boot protocol requires. The
+// devicetree and kernel addresses are stored at arm64PurgatoryDTB and
+// arm64PurgatoryKernel.
+var arm64Purgatory = []uint32{
+	0x580000c0, // ldr x0, dtb
+	0x580000e4, // ldr x4, kernel
+	0xaa1f03e1, // mov x1, xzr
+	0xaa1f03e2, // mov x2, xzr
+	0xaa1f03e3, // mov x3, xzr
+	0xd61f0080, // br x4
+}
+
+const (
+	arm64PurgatoryDTB    = 24
+	arm64PurgatoryKernel = 32
+	arm64PurgatorySize   = 40
+)
+
+// isArm64Image checks for the arm64 Image header
+func isArm64Image(kernel []byte) bool {
+	return len(kernel) >= 64 && bytes.Equal(kernel[arm64Magic:arm64Magic+4], []byte("ARM\x64"))
+}
+
+// planArm64 lays out an arm64 Image, its initrd, the devicetree with
+// /chosen updated and the purgatory, and returns the entry point. Without
+// dtbPath the running devicetree is passed on.
+func planArm64(plan *segmentPlan, kernel, initrd []byte, dtbPath, cmdline string) (uint64, error) {
+	textOffset := binary.LittleEndian.Uint64(kernel[arm64TextOffset:])
+	imageSize := binary.LittleEndian.Uint64(kernel[arm64ImageSize:])
+	if imageSize == 0 {
+		return 0, fmt.Errorf("arm64 Image is too old, its header has no image size")
+	}
+
+	// The kernel starts text_offset bytes above a 2MB aligned base
+	kernelBuf := append(make([]byte, textOffset), kernel...)
+	base, err := plan.add(kernelBuf, textOffset+imageSize, arm64KernelAlign, 0, ^uint64(0))
+	if err != nil {
+		return 0, fmt.Errorf("placing kernel: %v", err)
+	}
+	kernelAddr := base + textOffset
+
+	if dtbPath == "" {
+		dtbPath = "/sys/firmware/fdt"
+	}
+	dtb, err := os.ReadFile(dtbPath)
+	if err != nil {
+		return 0, fmt.Errorf("reading devicetree: %v", err)
+	}
+	tree, err := fdt.Parse(dtb)
+	if err != nil {
+		return 0, fmt.Errorf("parsing devicetree: %v", err)
+	}
+	chosen := tree.Root.AddChild("chosen")
+
+	if len(initrd) > 0 {
+		initrdAddr, err := plan.add(initrd, 0, pageSize, 0, ^uint64(0))
+		if err != nil {
+			return 0, fmt.Errorf("placing initrd: %v", err)
+		}
+		chosen.SetProperty("linux,initrd-start", be64(initrdAddr))
+		chosen.SetProperty("linux,initrd-end", be64(initrdAddr+uint64(len(initrd))))
+	} else {
+		chosen.DeleteProperty("linux,initrd-start")
+		chosen.DeleteProperty("linux,initrd-end")
+	}
+	updateChosen(chosen, cmdline)
+
+	dtbAddr, err := plan.add(tree.Marshal(), 0, pageSize, 0, ^uint64(0))
+	if err != nil {
+		return 0, fmt.Errorf("placing devicetree: %v", err)
+	}
+
+	purgatory := make([]byte, arm64PurgatorySize)
+	for i, insn := range arm64Purgatory {
+		binary.LittleEndian.PutUint32(purgatory[i*4:], insn)
+	}
+	binary.LittleEndian.PutUint64(purgatory[arm64PurgatoryDTB:], dtbAddr)
+	binary.LittleEndian.PutUint64(purgatory[arm64PurgatoryKernel:], kernelAddr)
+	entry, err := plan.add(purgatory, 0, pageSize, 0, ^uint64(0))
+	if err != nil {
+		return 0, fmt.Errorf("placing purgatory: %v", err)
+	}
+	return entry, nil
+}
+
+// updateChosen sets the command line of /chosen and drops what only
+// applied to the running kernel. A KASLR seed the kernel consumed is
+// renewed.
+func updateChosen(chosen *fdt.Node, cmdline string) {
+	chosen.SetProperty("bootargs", append([]byte(cmdline), 0))
+	chosen.DeleteProperty("linux,elfcorehdr")
+	chosen.DeleteProperty("linux,usable-memory-range")
+
+	if chosen.Property("kaslr-seed") != nil {
+		seed := make([]byte, 8)
+		if _, err := rand.Read(seed); err == nil {
+			chosen.SetProperty("kaslr-seed", seed)
+		}
+	}
+}
+
+// be64 encodes a big-endian 64-bit devicetree cell pair
+func be64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,32 @@
+package kexec
+
+import "testing"
+
+// TestArm64PurgatoryLayout checks that the literal loads of the purgatory
+// read the slots patched by planArm64 and that the code ends before them
+func TestArm64PurgatoryLayout(t *testing.T) {
+	if end := len(arm64Purgatory) * 4; end > arm64PurgatoryDTB {
+		t.Errorf("code ends at %d, after the devicetree slot at %d", end, arm64PurgatoryDTB)
+	}
+	if arm64PurgatoryDTB+8 > arm64PurgatoryKernel || arm64PurgatoryKernel+8 > arm64PurgatorySize {
+		t.Errorf("slots at %d and %d overlap or exceed the size %d", arm64PurgatoryDTB, arm64PurgatoryKernel, arm64PurgatorySize)
+	}
+
+	// ldr xt, literal loads from pc + imm19 * 4
+	slots := map[uint32]int{}
+	for i, insn := range arm64Purgatory {
+		if insn&0xff000000 != 0x58000000 {
+			continue
+		}
+		slots[insn&0x1f] = i*4 + int((insn>>5)&0x7ffff)*4
+	}
+	if slots[0] != arm64PurgatoryDTB {
+		t.Errorf("x0 loaded from %d, want the devicetree slot at %d", slots[0], arm64PurgatoryDTB)
+	}
+	if slots[4] != arm64PurgatoryKernel {
+		t.Errorf("x4 loaded from %d, want the kernel slot at %d", slots[4], arm64PurgatoryKernel)
+	}
+	if last := arm64Purgatory[len(arm64Purgatory)-1]; last != 0xd61f0080 {
+		t.Errorf("last instruction is 0x%08x, want br x4", last)
+	}
+}
@@ -0,0 +1,156 @@
+package kexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Backend loads a kernel for kexec and boots it
+type Backend interface {
+	// Name returns the name used to select the backend
+	Name() string
+	// Load loads a kernel with an optional initrd and devicetree
+	Load(kernelPath, initrdPath, dtbPath, cmdline string) error
+	// Execute boots the loaded kernel
+	Execute() error
+}
+
+// backends are the available backends, tried in order by the auto backend
+var backends = []Backend{
+	fileLoadBackend{},
+	loadBackend{},
+	toolsBackend{},
+}
+
+// BackendName selects the backend used to load kernels. "auto" uses
+// kexec_file_load where possible, then kexec_load, and falls back to
+// kexec-tools.
+var BackendName = "auto"
+
+// loaded is the backend that loaded the current kernel
+var loaded Backend
+
+// BackendNames returns the names accepted by BackendName
+func BackendNames() []string {
+	names := []string{"auto"}
+	for _, backend := range backends {
+		names = append(names, backend.Name())
+	}
+	return names
+}
+
+// CheckBackend reports an error if a backend name is not known
+func CheckBackend(name string) error {
+	if name == "auto" {
+		return nil
+	}
+	_, err := lookupBackend(name)
+	return err
+}
+
+// lookupBackend finds a backend by name
+func lookupBackend(name string) (Backend, error) {
+	for _, backend := range backends {
+		if backend.Name() == name {
+			return backend, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown kexec backend %q (known: %s)", name, strings.Join(BackendNames(), ", "))
+}
+
+// loadWithBackend loads a kernel with the selected backend. The auto
+// backend moves on to kexec_load when the running kernel lacks
+// kexec_file_load or a devicetree has to be passed, which kexec_file_load
+// cannot do, and to kexec-tools for kernels kexec_load cannot lay out. An
+// optional devicetree, one chosen automatically, is dropped for
+// kexec_file_load when kexec_load is not permitted, as under lockdown.
+func loadWithBackend(kernelPath, initrdPath, dtbPath, cmdline string, dtbOptional bool) error {
+	if BackendName != "auto" {
+		backend, err := lookupBackend(BackendName)
+		if err != nil {
+			return err
+		}
+		return loadWith(backend, kernelPath, initrdPath, dtbPath, cmdline)
+	}
+
+	if dtbPath == "" {
+		err := loadWith(fileLoadBackend{}, kernelPath, initrdPath, dtbPath, cmdline)
+		if !isUnsupported(err) {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v, falling back to kexec_load\n", err)
+	}
+
+	err := loadWith(loadBackend{}, kernelPath, initrdPath, dtbPath, cmdline)
+	if dtbPath != "" && dtbOptional && errors.Is(err, syscall.EPERM) {
+		fmt.Fprintf(os.Stderr, "Warning: %v, retrying kexec_file_load with the running devicetree\n", err)
+		return loadWith(fileLoadBackend{}, kernelPath, initrdPath, "", cmdline)
+	}
+	if err != errUnsupportedImage && !isUnsupported(err) {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v, falling back to kexec-tools\n", err)
+	return loadWith(toolsBackend{}, kernelPath, initrdPath, dtbPath, cmdline)
+}
+
+// loadWith loads a kernel with backend and remembers it for Execute
+func loadWith(backend Backend, kernelPath, initrdPath, dtbPath, cmdline string) error {
+	if Debug {
+		fmt.Fprintf(os.Stderr, "Loading with the %s backend\n", backend.Name())
+	}
+	if err := backend.Load(kernelPath, initrdPath, dtbPath, cmdline); err != nil {
+		return err
+	}
+	loaded = backend
+	return nil
+}
+
+// Execute boots the loaded kernel
+func Execute() error {
+	if loaded == nil {
+		// Loaded by another process, the reboot call boots it all the same
+		return fileLoadBackend{}.Execute()
+	}
+	return loaded.Execute()
+}
+
+// SyscallError is a failed kexec system call
+type SyscallError struct {
+	Call  string
+	Errno syscall.Errno
+}
+
+// errnoMessages explains the errors of the kexec system calls
+var errnoMessages = map[syscall.Errno]string{
+	syscall.ENOSYS:        "not supported by the running kernel",
+	syscall.EPERM:         "not permitted, needs CAP_SYS_BOOT and kexec enabled (kernel.kexec_load_disabled)",
+	syscall.EKEYREJECTED:  "signature rejected",
+	syscall.ENOKEY:        "no key to verify the kernel signature",
+	syscall.EBADMSG:       "kernel signature invalid",
+	syscall.ENOEXEC:       "kernel image format not supported",
+	syscall.EBUSY:         "another kexec operation is in progress",
+	syscall.EINVAL:        "invalid kernel, initrd or command line",
+	syscall.ENOMEM:        "not enough memory for the kernel",
+	syscall.EMSGSIZE:      "too many segments",
+	syscall.EADDRNOTAVAIL: "segments outside usable memory",
+}
+
+func (e *SyscallError) Error() string {
+	if message, ok := errnoMessages[e.Errno]; ok {
+		return fmt.Sprintf("%s: %s", e.Call, message)
+	}
+	return fmt.Sprintf("%s: %v", e.Call, e.Errno)
+}
+
+func (e *SyscallError) Unwrap() error {
+	return e.Errno
+}
+
+// isUnsupported checks if err means the running kernel lacks a system call
+func isUnsupported(err error) bool {
+	syscallErr, ok := err.(*SyscallError)
+	return ok && syscallErr.Errno == syscall.ENOSYS
+}
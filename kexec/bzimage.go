@@ -0,0 +1,256 @@
+package kexec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Offsets in struct boot_params, the "zero page", and its setup header
+const (
+	bpAcpiRsdpAddr      = 0x070
+	bpExtRamdiskImage   = 0x0c0
+	bpExtRamdiskSize    = 0x0c4
+	bpExtCmdLinePtr     = 0x0c8
+	bpE820Entries       = 0x1e8
+	bpSetupSects        = 0x1f1
+	bpBootFlag          = 0x1fe
+	bpJump              = 0x200
+	bpHeader            = 0x202
+	bpVersion           = 0x206
+	bpTypeOfLoader      = 0x210
+	bpCode32Start       = 0x214
+	bpRamdiskImage      = 0x218
+	bpRamdiskSize       = 0x21c
+	bpCmdLinePtr        = 0x228
+	bpInitrdAddrMax     = 0x22c
+	bpKernelAlignment   = 0x230
+	bpRelocatableKernel = 0x234
+	bpXLoadFlags        = 0x236
+	bpCmdlineSize       = 0x238
+	bpInitSize          = 0x260
+	bpE820Table         = 0x2d0
+)
+
+const (
+	xlfKernel64     = 1 << 0 // 64-bit entry point at load address + 0x200
+	e820MaxEntries  = 128
+	e820EntrySize   = 20
+	bzImageMinAddr  = 1 << 20 // Keep clear of legacy low memory
+	bzImageMaxAddr  = 1<<32 - 1
+	bzImageMinProto = 0x020c // 2.12 introduced xloadflags
+)
+
+// e820Types maps /sys/firmware/memmap types to E820 types
+var e820Types = map[string]uint32{
+	"System RAM":                 1,
+	"Reserved":                   2,
+	"ACPI Tables":                3,
+	"ACPI Non-volatile Storage":  4,
+	"Unusable memory":            5,
+	"Persistent Memory (legacy)": 12,
+	"Persistent Memory":          7,
+	"Soft Reserved":              0xefffffff,
+}
+
+// x86Purgatory loads a GDT with the boot protocol's __BOOT_CS (0x10) and
+// __BOOT_DS (0x18) selectors and a stack, then enters the kernel's 64-bit
+// entry point with rsi holding the boot_params address. The GDT pointer's
+// base, boot_params and entry addresses are patched in at the offsets
+// below. The stack is the end of the purgatory's page.
+var x86Purgatory = []byte{
+	0xfa,                            // cli
+	0x0f, 0x01, 0x15, 0x38, 0, 0, 0, // lgdt [rip+56] (gdtr)
+	0xb8, 0x18, 0, 0, 0, // mov eax, 0x18
+	0x8e, 0xd8, // mov ds, ax
+	0x8e, 0xc0, // mov es, ax
+	0x8e, 0xd0, // mov ss, ax
+	0x8e, 0xe0, // mov fs, ax
+	0x8e, 0xe8, // mov gs, ax
+	0x48, 0x8d, 0x25, 0xe2, 0x0f, 0, 0, // lea rsp, [rip+4066] (page end)
+	0x6a, 0x10, // push 0x10
+	0x48, 0x8d, 0x05, 0x03, 0, 0, 0, // lea rax, [rip+3] (next)
+	0x50,       // push rax
+	0x48, 0xcb, // lretq
+	// next:
+	0x48, 0x8b, 0x35, 0x3f, 0, 0, 0, // mov rsi, [rip+63] (boot_params)
+	0x48, 0x8b, 0x05, 0x40, 0, 0, 0, // mov rax, [rip+64] (entry)
+	0xff, 0xe0, // jmp rax
+}
+
+const (
+	x86PurgatoryGDTR       = 64
+	x86PurgatoryGDT        = 80
+	x86PurgatoryBootParams = 112
+	x86PurgatoryEntry      = 120
+	x86PurgatorySize       = 128
+)
+
+// x86GDT holds the null descriptors and the 64-bit code and flat data
+// segments at selectors 0x10 and 0x18
+var x86GDT = []uint64{0, 0, 0x00af9a000000ffff, 0x00cf92000000ffff}
+
+// isBzImage checks for the x86 boot protocol header
+func isBzImage(kernel []byte) bool {
+	return len(kernel) >= bpE820Table &&
+		binary.LittleEndian.Uint16(kernel[bpBootFlag:]) == 0xaa55 &&
+		string(kernel[bpHeader:bpHeader+4]) == "HdrS"
+}
+
+// planBzImage lays out an x86-64 bzImage, its initrd, command line, boot
+// parameters and the purgatory, and returns the entry point. The new
+// kernel gets the firmware memory map and ACPI tables but no EFI runtime
+// services or framebuffer, so it boots as on legacy firmware.
+func planBzImage(plan *segmentPlan, kernel, initrd []byte, cmdline string) (uint64, error) {
+	version := binary.LittleEndian.Uint16(kernel[bpVersion:])
+	xloadflags := binary.LittleEndian.Uint16(kernel[bpXLoadFlags:])
+	if version < bzImageMinProto || xloadflags&xlfKernel64 == 0 || kernel[bpRelocatableKernel] == 0 {
+		return 0, fmt.Errorf("bzImage is not a relocatable 64-bit kernel of boot protocol 2.12 or later")
+	}
+
+	setupSects := int(kernel[bpSetupSects])
+	if setupSects == 0 {
+		setupSects = 4
+	}
+	setupSize := (setupSects + 1) * 512
+	if setupSize >= len(kernel) {
+		return 0, fmt.Errorf("bzImage is truncated")
+	}
+
+	// boot_params starts as a copy of the setup header
+	bootParams := make([]byte, pageSize)
+	headerEnd := bpJump + 2 + int(kernel[bpJump+1])
+	copy(bootParams[bpSetupSects:headerEnd], kernel[bpSetupSects:headerEnd])
+	bootParams[bpTypeOfLoader] = 0xff
+
+	if maxLen := binary.LittleEndian.Uint32(kernel[bpCmdlineSize:]); uint32(len(cmdline)) > maxLen {
+		return 0, fmt.Errorf("command line is longer than the kernel's limit of %d bytes", maxLen)
+	}
+
+	align := uint64(binary.LittleEndian.Uint32(kernel[bpKernelAlignment:]))
+	initSize := uint64(binary.LittleEndian.Uint32(kernel[bpInitSize:]))
+	kernelAddr, err := plan.add(kernel[setupSize:], initSize, align, bzImageMinAddr, bzImageMaxAddr)
+	if err != nil {
+		return 0, fmt.Errorf("placing kernel: %v", err)
+	}
+	binary.LittleEndian.PutUint32(bootParams[bpCode32Start:], uint32(kernelAddr))
+
+	if len(initrd) > 0 {
+		initrdMax := uint64(binary.LittleEndian.Uint32(kernel[bpInitrdAddrMax:]))
+		initrdAddr, err := plan.add(initrd, 0, pageSize, bzImageMinAddr, initrdMax)
+		if err != nil {
+			return 0, fmt.Errorf("placing initrd: %v", err)
+		}
+		putSplit32(bootParams, bpRamdiskImage, bpExtRamdiskImage, initrdAddr)
+		putSplit32(bootParams, bpRamdiskSize, bpExtRamdiskSize, uint64(len(initrd)))
+	}
+
+	cmdlineAddr, err := plan.add(append([]byte(cmdline), 0), 0, pageSize, bzImageMinAddr, bzImageMaxAddr)
+	if err != nil {
+		return 0, fmt.Errorf("placing command line: %v", err)
+	}
+	putSplit32(bootParams, bpCmdLinePtr, bpExtCmdLinePtr, cmdlineAddr)
+
+	if err := fillE820(bootParams); err != nil {
+		return 0, err
+	}
+	if rsdp := readRSDP(); rsdp != 0 {
+		binary.LittleEndian.PutUint64(bootParams[bpAcpiRsdpAddr:], rsdp)
+	}
+
+	bootParamsAddr, err := plan.add(bootParams, 0, pageSize, bzImageMinAddr, bzImageMaxAddr)
+	if err != nil {
+		return 0, fmt.Errorf("placing boot parameters: %v", err)
+	}
+
+	purgatory := make([]byte, x86PurgatorySize)
+	copy(purgatory, x86Purgatory)
+	entry, err := plan.add(purgatory, pageSize, pageSize, bzImageMinAddr, bzImageMaxAddr)
+	if err != nil {
+		return 0, fmt.Errorf("placing purgatory: %v", err)
+	}
+	binary.LittleEndian.PutUint16(purgatory[x86PurgatoryGDTR:], uint16(len(x86GDT)*8-1))
+	binary.LittleEndian.PutUint64(purgatory[x86PurgatoryGDTR+2:], entry+x86PurgatoryGDT)
+	for i, desc := range x86GDT {
+		binary.LittleEndian.PutUint64(purgatory[x86PurgatoryGDT+i*8:], desc)
+	}
+	binary.LittleEndian.PutUint64(purgatory[x86PurgatoryBootParams:], bootParamsAddr)
+	binary.LittleEndian.PutUint64(purgatory[x86PurgatoryEntry:], kernelAddr+0x200)
+	return entry, nil
+}
+
+// putSplit32 stores a 64-bit value as a low half and an extended high half
+func putSplit32(bootParams []byte, lowOffset, highOffset int, v uint64) {
+	binary.LittleEndian.PutUint32(bootParams[lowOffset:], uint32(v))
+	binary.LittleEndian.PutUint32(bootParams[highOffset:], uint32(v>>32))
+}
+
+// fillE820 copies the firmware memory map from /sys/firmware/memmap into
+// the boot parameters
+func fillE820(bootParams []byte) error {
+	dirs, err := filepath.Glob("/sys/firmware/memmap/*")
+	if err != nil || len(dirs) == 0 {
+		return fmt.Errorf("no firmware memory map in /sys/firmware/memmap")
+	}
+
+	count := 0
+	for _, dir := range dirs {
+		start, err1 := readHexFile(filepath.Join(dir, "start"))
+		end, err2 := readHexFile(filepath.Join(dir, "end"))
+		typeName, err3 := os.ReadFile(filepath.Join(dir, "type"))
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		e820Type, ok := e820Types[strings.TrimSpace(string(typeName))]
+		if !ok {
+			e820Type = e820Types["Reserved"]
+		}
+		if count == e820MaxEntries {
+			return fmt.Errorf("firmware memory map has more than %d entries", e820MaxEntries)
+		}
+
+		entry := bootParams[bpE820Table+count*e820EntrySize:]
+		binary.LittleEndian.PutUint64(entry[0:], start)
+		binary.LittleEndian.PutUint64(entry[8:], end-start+1)
+		binary.LittleEndian.PutUint32(entry[16:], e820Type)
+		count++
+	}
+	bootParams[bpE820Entries] = byte(count)
+	return nil
+}
+
+// readRSDP returns the ACPI RSDP address from the EFI system table, or 0
+func readRSDP() uint64 {
+	data, err := os.ReadFile("/sys/firmware/efi/systab")
+	if err != nil {
+		return 0
+	}
+
+	var rsdp uint64
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		addr, err := strconv.ParseUint(strings.TrimPrefix(value, "0x"), 16, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "ACPI20":
+			return addr
+		case "ACPI":
+			rsdp = addr
+		}
+	}
+	return rsdp
+}
+
+// readHexFile reads a 0x-prefixed hexadecimal number from a sysfs file
+func readHexFile(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"), 16, 64)
+}
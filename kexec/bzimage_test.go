@@ -0,0 +1,55 @@
+package kexec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// TestX86PurgatoryLayout checks that the RIP-relative operands of the
+// purgatory point at the fields patched by planBzImage
+func TestX86PurgatoryLayout(t *testing.T) {
+	if len(x86Purgatory) > x86PurgatoryGDTR {
+		t.Errorf("code ends at %d, after the GDT pointer at %d", len(x86Purgatory), x86PurgatoryGDTR)
+	}
+	if x86PurgatoryGDTR+10 > x86PurgatoryGDT ||
+		x86PurgatoryGDT+len(x86GDT)*8 > x86PurgatoryBootParams ||
+		x86PurgatoryBootParams+8 > x86PurgatoryEntry ||
+		x86PurgatoryEntry+8 > x86PurgatorySize {
+		t.Error("patched fields overlap or exceed the purgatory size")
+	}
+
+	tests := []struct {
+		name   string
+		opcode []byte // Up to the ModRM byte, followed by a 32-bit displacement
+		target int
+	}{
+		{"lgdt", []byte{0x0f, 0x01, 0x15}, x86PurgatoryGDTR},
+		{"lea rsp", []byte{0x48, 0x8d, 0x25}, pageSize},
+		{"mov rsi", []byte{0x48, 0x8b, 0x35}, x86PurgatoryBootParams},
+		{"mov rax", []byte{0x48, 0x8b, 0x05}, x86PurgatoryEntry},
+	}
+	for _, tt := range tests {
+		i := bytes.Index(x86Purgatory, tt.opcode)
+		if i < 0 {
+			t.Errorf("%s not found", tt.name)
+			continue
+		}
+		end := i + len(tt.opcode) + 4
+		target := end + int(int32(binary.LittleEndian.Uint32(x86Purgatory[end-4:])))
+		if target != tt.target {
+			t.Errorf("%s refers to %d, want %d", tt.name, target, tt.target)
+		}
+	}
+
+	// The far return continues right after itself in the new code segment
+	i := bytes.Index(x86Purgatory, []byte{0x48, 0x8d, 0x05})
+	lret := bytes.Index(x86Purgatory, []byte{0x48, 0xcb})
+	if i < 0 || lret < 0 {
+		t.Fatal("far return sequence not found")
+	}
+	next := i + 7 + int(int32(binary.LittleEndian.Uint32(x86Purgatory[i+3:])))
+	if next != lret+2 {
+		t.Errorf("far return lands at %d, want %d", next, lret+2)
+	}
+}
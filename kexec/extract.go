@@ -0,0 +1,200 @@
+package kexec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/timoxa0/kxmenu/entry"
+)
+
+// extractedFiles holds the temporary files unpacked from a boot image
+type extractedFiles struct {
+	kernel string
+	initrd string
+	dtb    string
+}
+
+// extractUKI writes the kernel, initrd and devicetree sections of a unified
+// kernel image to temporary files
+func extractUKI(ukiPath string) (*extractedFiles, error) {
+	fmt.Println("Extracting unified kernel image...")
+
+	uki, err := entry.OpenUKI(ukiPath)
+	if err != nil {
+		return nil, err
+	}
+	defer uki.Close()
+
+	files := &extractedFiles{}
+	sections := []struct {
+		name string
+		dest *string
+	}{
+		{".linux", &files.kernel},
+		{".initrd", &files.initrd},
+		{".dtb", &files.dtb},
+	}
+
+	for _, section := range sections {
+		data, err := uki.Section(section.name)
+		if err != nil {
+			files.remove()
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+
+		path, err := writeTemp("kexec-uki-*"+section.name, data)
+		if err != nil {
+			files.remove()
+			return nil, err
+		}
+		*section.dest = path
+	}
+
+	return files, nil
+}
+
+// extractAndroidBoot writes the kernel, ramdisk and devicetree of an Android
+// boot to temporary files. With init_boot and vendor_boot images the ramdisk
+// is assembled the way the Android bootloader does it: vendor ramdisks
+// first, then the generic ramdisk, then the bootconfig trailer. The second
+// stage bootloader is not needed with kexec and stays in the image.
+func extractAndroidBoot(images entry.AndroidImages) (*extractedFiles, error) {
+	fmt.Println("Extracting Android boot image...")
+
+	img, err := entry.OpenAndroidBootImage(images.Boot)
+	if err != nil {
+		return nil, err
+	}
+	if img.Kernel.Size == 0 {
+		return nil, fmt.Errorf("%s: boot image has no kernel", images.Boot)
+	}
+
+	kernel, err := img.ReadSection(img.Kernel)
+	if err != nil {
+		return nil, err
+	}
+	dtb, err := img.ReadSection(img.DTB)
+	if err != nil {
+		return nil, err
+	}
+
+	var ramdisk, bootconfig []byte
+	if images.VendorBoot != "" {
+		vendor, err := entry.OpenVendorBootImage(images.VendorBoot)
+		if err != nil {
+			return nil, err
+		}
+		for _, section := range vendor.BootRamdisks() {
+			data, err := vendor.ReadSection(section)
+			if err != nil {
+				return nil, err
+			}
+			ramdisk = append(ramdisk, data...)
+		}
+		if vendor.DTB.Size > 0 {
+			if dtb, err = vendor.ReadSection(vendor.DTB); err != nil {
+				return nil, err
+			}
+		}
+		if bootconfig, err = vendor.ReadSection(vendor.Bootconfig); err != nil {
+			return nil, err
+		}
+	}
+
+	// The generic ramdisk moved from boot to init_boot in Android 13
+	generic, err := img.ReadSection(img.Ramdisk)
+	if err != nil {
+		return nil, err
+	}
+	if images.InitBoot != "" {
+		initBoot, err := entry.OpenAndroidBootImage(images.InitBoot)
+		if err != nil {
+			return nil, err
+		}
+		if generic, err = initBoot.ReadSection(initBoot.Ramdisk); err != nil {
+			return nil, err
+		}
+	}
+	ramdisk = append(ramdisk, generic...)
+
+	if len(bootconfig) > 0 {
+		ramdisk = appendBootconfig(ramdisk, bootconfig)
+	}
+
+	files := &extractedFiles{}
+	sections := []struct {
+		name string
+		data []byte
+		dest *string
+	}{
+		{"kernel", kernel, &files.kernel},
+		{"ramdisk", ramdisk, &files.initrd},
+		{"dtb", dtb, &files.dtb},
+	}
+
+	for _, section := range sections {
+		if len(section.data) == 0 {
+			continue
+		}
+
+		path, err := writeTemp("kexec-android-*."+section.name, section.data)
+		if err != nil {
+			files.remove()
+			return nil, err
+		}
+		*section.dest = path
+	}
+
+	return files, nil
+}
+
+// appendBootconfig appends bootconfig parameters to an initrd in the
+// format the kernel looks for at its end: the data padded to 4 bytes, its
+// size and checksum, and a magic string
+func appendBootconfig(initrd, bootconfig []byte) []byte {
+	const magic = "#BOOTCONFIG\n"
+
+	data := append([]byte{}, bootconfig...)
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+
+	var checksum uint32
+	for _, b := range data {
+		checksum += uint32(b)
+	}
+
+	initrd = append(initrd, data...)
+	initrd = binary.LittleEndian.AppendUint32(initrd, uint32(len(data)))
+	initrd = binary.LittleEndian.AppendUint32(initrd, checksum)
+	return append(initrd, magic...)
+}
+
+// remove deletes the extracted files
+func (f *extractedFiles) remove() {
+	for _, path := range []string{f.kernel, f.initrd, f.dtb} {
+		if path != "" {
+			os.Remove(path)
+		}
+	}
+}
+
+// writeTemp writes data to a new temporary file and returns its path
+func writeTemp(pattern string, data []byte) (string, error) {
+	tmpFile, err := os.CreateTemp("/tmp", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer tmpFile.Close()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	return tmpFile.Name(), nil
+}
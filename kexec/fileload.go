@@ -0,0 +1,79 @@
+package kexec
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// kexec_file_load flags
+const (
+	kexecFileNoInitramfs = 0x4
+)
+
+// fileLoadBackend loads kernels with the kexec_file_load system call. The
+// kernel parses the image itself and reuses the running devicetree, so
+// devicetrees cannot be passed.
+type fileLoadBackend struct{}
+
+func (fileLoadBackend) Name() string {
+	return "file"
+}
+
+func (fileLoadBackend) Load(kernelPath, initrdPath, dtbPath, cmdline string) error {
+	if dtbPath != "" {
+		return fmt.Errorf("kexec_file_load cannot pass a devicetree")
+	}
+	if sysKexecFileLoad < 0 {
+		return &SyscallError{Call: "kexec_file_load", Errno: syscall.ENOSYS}
+	}
+
+	kernel, err := os.Open(kernelPath)
+	if err != nil {
+		return err
+	}
+	defer kernel.Close()
+
+	initrdFd := ^uintptr(0) // -1, ignored with kexecFileNoInitramfs
+	flags := uintptr(kexecFileNoInitramfs)
+	if initrdPath != "" {
+		initrd, err := os.Open(initrdPath)
+		if err != nil {
+			return err
+		}
+		defer initrd.Close()
+		initrdFd = initrd.Fd()
+		flags = 0
+	}
+
+	// The length includes the terminating NUL
+	cmdlineBytes := append([]byte(cmdline), 0)
+
+	_, _, errno := syscall.Syscall6(uintptr(sysKexecFileLoad),
+		kernel.Fd(),
+		initrdFd,
+		uintptr(len(cmdlineBytes)),
+		uintptr(unsafe.Pointer(&cmdlineBytes[0])),
+		flags,
+		0)
+	if errno != 0 {
+		return &SyscallError{Call: "kexec_file_load", Errno: errno}
+	}
+	return nil
+}
+
+// Execute reboots into the loaded kernel. It only returns on failure.
+func (fileLoadBackend) Execute() error {
+	syscall.Sync()
+	if err := syscall.Reboot(syscall.LINUX_REBOOT_CMD_KEXEC); err != nil {
+		if errno, ok := err.(syscall.Errno); ok {
+			if errno == syscall.EINVAL {
+				return fmt.Errorf("reboot: no kernel loaded")
+			}
+			return &SyscallError{Call: "reboot", Errno: errno}
+		}
+		return err
+	}
+	return nil
+}
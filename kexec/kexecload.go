@@ -0,0 +1,250 @@
+package kexec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// pageSize is the granularity of kexec segments
+const pageSize = 4096
+
+// maxSegments is the most segments kexec_load accepts
+const maxSegments = 16
+
+// errUnsupportedImage means the kexec_load backend cannot lay out a kernel
+// image, leaving it to kexec-tools
+var errUnsupportedImage = errors.New("kernel image format not supported by the kexec_load backend")
+
+// loadBackend loads kernels with the kexec_load system call, laying out
+// the kernel, initrd, boot data and a small purgatory in memory itself.
+// It supports arm64 Image and x86-64 bzImage kernels of the running
+// architecture.
+type loadBackend struct{}
+
+func (loadBackend) Name() string {
+	return "load"
+}
+
+func (loadBackend) Load(kernelPath, initrdPath, dtbPath, cmdline string) error {
+	kernel, err := os.ReadFile(kernelPath)
+	if err != nil {
+		return err
+	}
+
+	// The purgatory is code of the running architecture, and devicetrees
+	// are only passed to arm64 kernels
+	arch := imageArch(kernel)
+	if arch == "" || arch != runtime.GOARCH || (arch == "amd64" && dtbPath != "") {
+		return errUnsupportedImage
+	}
+
+	var initrd []byte
+	if initrdPath != "" {
+		initrd, err = os.ReadFile(initrdPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	ram, err := readSystemRAM("/proc/iomem")
+	if err != nil {
+		return err
+	}
+	plan := &segmentPlan{ram: ram}
+
+	var entry uint64
+	if arch == "arm64" {
+		entry, err = planArm64(plan, kernel, initrd, dtbPath, cmdline)
+	} else {
+		entry, err = planBzImage(plan, kernel, initrd, cmdline)
+	}
+	if err != nil {
+		return err
+	}
+
+	return kexecLoad(entry, plan.segments)
+}
+
+// imageArch returns the GOARCH of the kernels the backend lays out, or ""
+// for other kernels
+func imageArch(kernel []byte) string {
+	switch {
+	case isArm64Image(kernel):
+		return "arm64"
+	case isBzImage(kernel):
+		return "amd64"
+	}
+	return ""
+}
+
+// Execute reboots into the loaded kernel like the kexec_file_load backend
+func (loadBackend) Execute() error {
+	return fileLoadBackend{}.Execute()
+}
+
+// segment is a buffer copied to physical memory at mem. Memory after the
+// buffer up to memsz is zeroed.
+type segment struct {
+	buf   []byte
+	mem   uint64
+	memsz uint64
+}
+
+// kexecSegment is struct kexec_segment of the kexec_load system call
+type kexecSegment struct {
+	buf   uintptr
+	bufsz uintptr
+	mem   uintptr
+	memsz uintptr
+}
+
+// kexecLoad passes segments to the kexec_load system call
+func kexecLoad(entry uint64, segments []segment) error {
+	if len(segments) > maxSegments {
+		return &SyscallError{Call: "kexec_load", Errno: syscall.EMSGSIZE}
+	}
+
+	raw := make([]kexecSegment, len(segments))
+	for i, seg := range segments {
+		raw[i] = kexecSegment{bufsz: uintptr(len(seg.buf)), mem: uintptr(seg.mem), memsz: uintptr(seg.memsz)}
+		if len(seg.buf) > 0 {
+			raw[i].buf = uintptr(unsafe.Pointer(&seg.buf[0]))
+		}
+	}
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_KEXEC_LOAD,
+		uintptr(entry),
+		uintptr(len(raw)),
+		uintptr(unsafe.Pointer(&raw[0])),
+		0, // KEXEC_ARCH_DEFAULT
+		0, 0)
+	runtime.KeepAlive(segments)
+	if errno != 0 {
+		return &SyscallError{Call: "kexec_load", Errno: errno}
+	}
+	return nil
+}
+
+// memRange is a physical memory range, end exclusive
+type memRange struct {
+	start, end uint64
+}
+
+// segmentPlan places segments in free system RAM
+type segmentPlan struct {
+	ram      []memRange
+	segments []segment
+}
+
+// add places buf in memory of at least memsz bytes, aligned to align and
+// between low and high, and returns its address. The lowest free address
+// is used.
+func (p *segmentPlan) add(buf []byte, memsz, align, low, high uint64) (uint64, error) {
+	memsz = alignUp(max(memsz, uint64(len(buf))), pageSize)
+	align = max(align, pageSize)
+
+	for _, r := range p.ram {
+		addr := alignUp(max(r.start, low), align)
+		for addr+memsz <= r.end && addr+memsz-1 <= high {
+			overlap := p.overlapping(addr, memsz)
+			if overlap == nil {
+				p.segments = append(p.segments, segment{buf: buf, mem: addr, memsz: memsz})
+				return addr, nil
+			}
+			addr = alignUp(overlap.mem+overlap.memsz, align)
+		}
+	}
+	return 0, fmt.Errorf("no free memory for a %d byte segment", memsz)
+}
+
+// overlapping returns a placed segment overlapping a range, or nil
+func (p *segmentPlan) overlapping(addr, size uint64) *segment {
+	for i := range p.segments {
+		seg := &p.segments[i]
+		if addr < seg.mem+seg.memsz && seg.mem < addr+size {
+			return seg
+		}
+	}
+	return nil
+}
+
+// readSystemRAM returns the system RAM listed in /proc/iomem, without the
+// reserved and crash kernel regions inside it
+func readSystemRAM(iomemPath string) ([]memRange, error) {
+	file, err := os.Open(iomemPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ram, excluded []memRange
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		span, name, ok := strings.Cut(line, " : ")
+		if !ok {
+			continue
+		}
+		startHex, endHex, ok := strings.Cut(strings.TrimSpace(span), "-")
+		if !ok {
+			continue
+		}
+		start, err1 := strconv.ParseUint(startHex, 16, 64)
+		end, err2 := strconv.ParseUint(endHex, 16, 64)
+		if err1 != nil || err2 != nil || end < start {
+			continue
+		}
+
+		switch {
+		case !strings.HasPrefix(line, " ") && name == "System RAM":
+			ram = append(ram, memRange{start, end + 1})
+		case strings.HasPrefix(line, " ") && (name == "reserved" || name == "Crash kernel"):
+			excluded = append(excluded, memRange{start, end + 1})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Unprivileged readers see every address as zero
+	if len(ram) == 0 || ram[len(ram)-1].end <= 1 {
+		return nil, fmt.Errorf("no system RAM in %s, reading it needs root", iomemPath)
+	}
+
+	for _, ex := range excluded {
+		ram = subtractRange(ram, ex)
+	}
+	sort.Slice(ram, func(i, j int) bool { return ram[i].start < ram[j].start })
+	return ram, nil
+}
+
+// subtractRange removes ex from the ranges
+func subtractRange(ranges []memRange, ex memRange) []memRange {
+	var result []memRange
+	for _, r := range ranges {
+		if ex.end <= r.start || ex.start >= r.end {
+			result = append(result, r)
+			continue
+		}
+		if r.start < ex.start {
+			result = append(result, memRange{r.start, ex.start})
+		}
+		if ex.end < r.end {
+			result = append(result, memRange{ex.end, r.end})
+		}
+	}
+	return result
+}
+
+// alignUp rounds n up to a multiple of align, a power of two
+func alignUp(n, align uint64) uint64 {
+	return (n + align - 1) &^ (align - 1)
+}
@@ -0,0 +1,145 @@
+package kexec
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestReadSystemRAM(t *testing.T) {
+	ram, err := readSystemRAM("testdata/iomem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []memRange{
+		{0x1000, 0xa0000},
+		{0x100000, 0x20000000},
+		{0x28000000, 0x3ff00000},
+		{0x100000000, 0x140000000},
+	}
+	if !reflect.DeepEqual(ram, want) {
+		t.Errorf("got %x, want %x", ram, want)
+	}
+
+	if _, err := readSystemRAM("testdata/iomem-unprivileged"); err == nil {
+		t.Error("zeroed addresses of an unprivileged reader accepted")
+	}
+	if _, err := readSystemRAM("testdata/missing"); err == nil {
+		t.Error("missing file accepted")
+	}
+}
+
+func TestSubtractRange(t *testing.T) {
+	ranges := []memRange{{0x1000, 0x5000}, {0x8000, 0x9000}}
+	tests := []struct {
+		name string
+		ex   memRange
+		want []memRange
+	}{
+		{"disjoint", memRange{0x5000, 0x8000}, []memRange{{0x1000, 0x5000}, {0x8000, 0x9000}}},
+		{"inside", memRange{0x2000, 0x3000}, []memRange{{0x1000, 0x2000}, {0x3000, 0x5000}, {0x8000, 0x9000}}},
+		{"start", memRange{0x0, 0x2000}, []memRange{{0x2000, 0x5000}, {0x8000, 0x9000}}},
+		{"end", memRange{0x4000, 0x6000}, []memRange{{0x1000, 0x4000}, {0x8000, 0x9000}}},
+		{"whole", memRange{0x8000, 0x9000}, []memRange{{0x1000, 0x5000}}},
+		{"spanning", memRange{0x3000, 0x8800}, []memRange{{0x1000, 0x3000}, {0x8800, 0x9000}}},
+	}
+	for _, tt := range tests {
+		got := subtractRange(ranges, tt.ex)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %x, want %x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSegmentPlanAdd(t *testing.T) {
+	type add struct {
+		size, memsz, align, low, high uint64
+		want                          uint64
+		fail                          bool
+	}
+	tests := []struct {
+		name string
+		adds []add
+	}{
+		{"lowest first", []add{
+			{size: 100, want: 0x1000},
+			{size: 0x1800, want: 0x2000},
+			{size: 1, want: 0x4000},
+		}},
+		{"memsz reserves space", []add{
+			{size: 10, memsz: 0x3000, want: 0x1000},
+			{size: 10, want: 0x4000},
+		}},
+		{"alignment", []add{
+			{size: 10, align: 0x100000, want: 0x100000},
+			{size: 10, align: 0x8000, want: 0x8000},
+		}},
+		{"next range", []add{
+			{size: 0xf000, want: 0x100000},
+		}},
+		{"bounds", []add{
+			{size: 10, low: 0x3000, want: 0x3000},
+			{size: 10, low: 0x3000, want: 0x4000},
+			{size: 0x2000, high: 0x2fff, want: 0x1000},
+			{size: 10, high: 0x2fff, fail: true},
+		}},
+		{"too large", []add{
+			{size: 0x200000, fail: true},
+		}},
+	}
+	for _, tt := range tests {
+		plan := &segmentPlan{ram: []memRange{{0x1000, 0xa000}, {0x100000, 0x180000}}}
+		for i, a := range tt.adds {
+			high := a.high
+			if high == 0 {
+				high = ^uint64(0)
+			}
+			addr, err := plan.add(make([]byte, a.size), a.memsz, a.align, a.low, high)
+			switch {
+			case a.fail && err == nil:
+				t.Errorf("%s: add %d placed at 0x%x, want failure", tt.name, i, addr)
+			case !a.fail && err != nil:
+				t.Errorf("%s: add %d: %v", tt.name, i, err)
+			case !a.fail && addr != a.want:
+				t.Errorf("%s: add %d placed at 0x%x, want 0x%x", tt.name, i, addr, a.want)
+			}
+		}
+	}
+}
+
+func TestLoadForeignArchitecture(t *testing.T) {
+	arm64 := make([]byte, 4096)
+	copy(arm64[arm64Magic:], "ARM\x64")
+	bzImage := make([]byte, 4096)
+	binary.LittleEndian.PutUint16(bzImage[bpBootFlag:], 0xaa55)
+	copy(bzImage[bpHeader:], "HdrS")
+
+	tests := []struct {
+		name   string
+		kernel []byte
+		arch   string
+	}{
+		{"arm64 Image", arm64, "arm64"},
+		{"bzImage", bzImage, "amd64"},
+		{"unknown", make([]byte, 4096), ""},
+	}
+	for _, tt := range tests {
+		if arch := imageArch(tt.kernel); arch != tt.arch {
+			t.Errorf("%s: architecture %q, want %q", tt.name, arch, tt.arch)
+		}
+		if tt.arch == runtime.GOARCH {
+			continue
+		}
+
+		path := filepath.Join(t.TempDir(), "kernel")
+		if err := os.WriteFile(path, tt.kernel, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := (loadBackend{}).Load(path, "", "", ""); err != errUnsupportedImage {
+			t.Errorf("%s on %s: got %v, want errUnsupportedImage", tt.name, runtime.GOARCH, err)
+		}
+	}
+}
@@ -1,17 +1,18 @@
 package kexec
 
 import (
-	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
 
+	"github.com/timoxa0/kxmenu/decompress"
 	"github.com/timoxa0/kxmenu/entry"
 )
 
+// Debug prints the kexec invocation before loading
+var Debug bool
+
 // LoadEntry handles kexec operations for boot entries
 func LoadEntry(entryFile, bootRoot string) error {
 	// Set defaults if not provided
@@ -33,54 +34,193 @@ func LoadEntry(entryFile, bootRoot string) error {
 
 // LoadEntryFromParsed handles kexec operations for an already parsed boot entry
 func LoadEntryFromParsed(bootEntry *entry.BootEntry, bootRoot string) error {
-	// Set default if not provided
+	if err := Load(bootEntry, bootRoot); err != nil {
+		return err
+	}
+
+	return Execute()
+}
+
+// Prepare expands GRUB environment variables in an entry, applies the
+// command line policy and picks a DTB for the running board. Entries are
+// prepared once: the menu prepares them before showing them, so the
+// editor changes the command line that boots and edits are kept as they
+// are. The entry's own BootRoot, if set, takes precedence over bootRoot.
+func Prepare(bootEntry *entry.BootEntry, bootRoot string) {
+	if bootEntry.Prepared {
+		return
+	}
+	bootEntry.Prepared = true
+	bootRoot = entryBootRoot(bootEntry, bootRoot)
+
+	// Expand GRUB environment variables such as $tuned_params
+	vars, err := entry.LoadVariables(bootRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to read variables: %v\n", err)
+		vars = map[string]string{}
+	}
+	for _, name := range bootEntry.ExpandVariables(vars) {
+		fmt.Fprintf(os.Stderr, "Warning: undefined variable $%s removed\n", name)
+	}
+
+	// Rewrite the command line with the global policy
+	applyCmdlinePolicy(bootEntry)
+
+	// Pick a DTB for the running board if the entry names none
+	if bootEntry.Devicetree == "" {
+		bootEntry.SelectDevicetree(bootRoot, entry.ReadBoard())
+	}
+}
+
+// entryBootRoot returns the directory an entry's paths are relative to:
+// its own BootRoot, bootRoot, or /mnt
+func entryBootRoot(bootEntry *entry.BootEntry, bootRoot string) string {
+	if bootEntry.BootRoot != "" {
+		return bootEntry.BootRoot
+	}
 	if bootRoot == "" {
-		bootRoot = "/mnt"
+		return "/mnt"
 	}
+	return bootRoot
+}
 
-	// Clean up tuned parameters
-	bootEntry.CleanupEntry()
+// Load loads the kernel of a parsed boot entry without executing it,
+// preparing it first if it was not. The entry's own BootRoot, if set,
+// takes precedence over bootRoot.
+func Load(bootEntry *entry.BootEntry, bootRoot string) error {
+	Prepare(bootEntry, bootRoot)
+	bootRoot = entryBootRoot(bootEntry, bootRoot)
 
 	// Print boot entry information
 	bootEntry.PrintEntry()
 
-	// Prepare kernel path and handle decompression if needed
-	kernelPath := filepath.Join(bootRoot, bootEntry.Linux)
-	if strings.HasPrefix(filepath.Base(bootEntry.Linux), "vmlinuz") {
-		decompressedPath, err := decompressKernel(kernelPath)
+	var kernelPath, dtbPath string
+	var initrdPaths []string
+
+	switch {
+	case bootEntry.Linux != "":
+		kernelPath = filepath.Join(bootRoot, bootEntry.Linux)
+
+	case bootEntry.Efi != "" || bootEntry.AndroidBoot != "":
+		// Unified kernel images and Android boot images carry the kernel,
+		// initrd and devicetree
+		var files *extractedFiles
+		var err error
+		if bootEntry.AndroidBoot != "" {
+			files, err = extractAndroidBoot(androidImages(bootEntry, bootRoot))
+		} else {
+			files, err = extractUKI(filepath.Join(bootRoot, bootEntry.Efi))
+		}
 		if err != nil {
-			return fmt.Errorf("decompression failed: %v", err)
+			return fmt.Errorf("failed to extract boot image: %v", err)
+		}
+		defer files.remove()
+
+		kernelPath = files.kernel
+		if files.initrd != "" {
+			initrdPaths = append(initrdPaths, files.initrd)
 		}
+		dtbPath = files.dtb
+
+	default:
+		return fmt.Errorf("entry has no linux kernel")
+	}
+
+	// Decompress the kernel if its data is compressed, whatever its name
+	decompressedPath, err := decompressKernel(kernelPath)
+	if err != nil {
+		return fmt.Errorf("decompression failed: %v", err)
+	}
+	if decompressedPath != kernelPath {
 		kernelPath = decompressedPath
 		defer os.Remove(decompressedPath)
 	}
 
+	for _, initrd := range bootEntry.Initrd {
+		initrdPaths = append(initrdPaths, filepath.Join(bootRoot, initrd))
+	}
+	if bootEntry.Devicetree != "" {
+		dtbPath = filepath.Join(bootRoot, bootEntry.Devicetree)
+	}
+
+	// Combine multiple initrds into one image, kexec accepts only one
+	initrdPath := ""
+	switch len(initrdPaths) {
+	case 0:
+	case 1:
+		initrdPath = initrdPaths[0]
+	default:
+		combinedPath, err := concatInitrds(initrdPaths)
+		if err != nil {
+			return fmt.Errorf("failed to combine initrds: %v", err)
+		}
+		initrdPath = combinedPath
+		defer os.Remove(combinedPath)
+	}
+
 	// Load kernel with kexec
-	err := loadKernel(kernelPath, bootRoot, bootEntry)
+	overlays := overlayPaths(bootEntry.DevicetreeOverlay, bootRoot)
+	dtbOptional := bootEntry.Devicetree != "" && bootEntry.DevicetreeReason != ""
+	err = loadKernel(kernelPath, initrdPath, dtbPath, overlays, bootEntry.Options, dtbOptional)
 	if err != nil {
 		return fmt.Errorf("failed to load kernel: %v", err)
 	}
 
-	return executeKexec()
+	// Count the attempt for boot assessment, the booted system marks the
+	// entry good. Read-only media only lose the count.
+	if err := bootEntry.CountBootAttempt(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	return nil
 }
 
-// decompressKernel decompresses a gzipped vmlinuz kernel to a temporary file
+// androidImages returns the paths of an entry's Android images below bootRoot
+func androidImages(bootEntry *entry.BootEntry, bootRoot string) entry.AndroidImages {
+	images := entry.AndroidImages{Boot: filepath.Join(bootRoot, bootEntry.AndroidBoot)}
+	if bootEntry.AndroidInitBoot != "" {
+		images.InitBoot = filepath.Join(bootRoot, bootEntry.AndroidInitBoot)
+	}
+	if bootEntry.AndroidVendorBoot != "" {
+		images.VendorBoot = filepath.Join(bootRoot, bootEntry.AndroidVendorBoot)
+	}
+	return images
+}
+
+// decompressKernel decompresses a kernel to a temporary file. The format
+// is detected from the data, uncompressed kernels keep their path. EFI
+// zboot images are unpacked to the Image they wrap.
 func decompressKernel(kernelPath string) (string, error) {
-	fmt.Println("Decompressing linux...")
+	data, err := os.ReadFile(kernelPath)
+	if err != nil {
+		return "", err
+	}
 
-	// Open the compressed kernel file
-	file, err := os.Open(kernelPath)
+	payload, compType, err := zbootPayload(data)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
+	if payload != nil {
+		fmt.Printf("Unpacking EFI zboot image (%s)...\n", compType)
+		if decompress.Detect(payload) == decompress.Uncompressed {
+			return "", fmt.Errorf("unsupported zboot compression %q", compType)
+		}
+		data = payload
+	}
 
-	// Create gzip reader
-	gzipReader, err := gzip.NewReader(file)
+	format := decompress.Detect(data)
+	if format == decompress.Uncompressed {
+		return kernelPath, nil
+	}
+	fmt.Printf("Decompressing linux (%s)...\n", format)
+
+	kernel, _, err := decompress.Decompress(data)
 	if err != nil {
 		return "", err
 	}
-	defer gzipReader.Close()
+	if payload != nil && !decompress.IsKernelImage(kernel) {
+		return "", fmt.Errorf("zboot payload is not a kernel image")
+	}
 
 	// Create temporary file for decompressed kernel
 	tmpFile, err := os.CreateTemp("/tmp", "kexec-decompressed-*.img")
@@ -89,9 +229,7 @@ func decompressKernel(kernelPath string) (string, error) {
 	}
 	defer tmpFile.Close()
 
-	// Copy decompressed data to temporary file
-	_, err = io.Copy(tmpFile, gzipReader)
-	if err != nil {
+	if _, err := tmpFile.Write(kernel); err != nil {
 		os.Remove(tmpFile.Name())
 		return "", err
 	}
@@ -99,41 +237,57 @@ func decompressKernel(kernelPath string) (string, error) {
 	return tmpFile.Name(), nil
 }
 
-// loadKernel loads the kernel using kexec with the specified parameters
-func loadKernel(kernelPath, bootRoot string, bootEntry *entry.BootEntry) error {
-	fmt.Println("Loading linux...")
-
-	args := []string{"--load", kernelPath}
+// concatInitrds concatenates initrd images in order into a temporary file.
+// The kernel unpacks concatenated cpio archives one after another.
+func concatInitrds(initrdPaths []string) (string, error) {
+	fmt.Println("Combining initrds...")
 
-	// Add initrd if specified
-	if bootEntry.Initrd != "" {
-		initrdPath := filepath.Join(bootRoot, bootEntry.Initrd)
-		args = append(args, "--initrd="+initrdPath)
+	tmpFile, err := os.CreateTemp("/tmp", "kexec-initrd-*.img")
+	if err != nil {
+		return "", err
 	}
+	defer tmpFile.Close()
 
-	// Add device tree if specified
-	if bootEntry.Devicetree != "" {
-		dtbPath := filepath.Join(bootRoot, bootEntry.Devicetree)
-		args = append(args, "--dtb="+dtbPath)
+	for _, initrdPath := range initrdPaths {
+		err := appendFile(tmpFile, initrdPath)
+		if err != nil {
+			os.Remove(tmpFile.Name())
+			return "", err
+		}
 	}
 
-	// Add command line options if specified
-	if bootEntry.Options != "" {
-		args = append(args, "--command-line="+bootEntry.Options)
-	}
+	return tmpFile.Name(), nil
+}
 
-	cmd := exec.Command("kexec", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// appendFile copies the contents of path to w
+func appendFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-	return cmd.Run()
+	_, err = io.Copy(w, file)
+	return err
 }
 
-// executeKexec executes the loaded kernel
-func executeKexec() error {
-	cmd := exec.Command("kexec", "-e")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// loadKernel loads the kernel using kexec with the specified parameters.
+// Overlays are merged into the devicetree first. An optional devicetree
+// may be left out if the backend cannot pass it.
+func loadKernel(kernelPath, initrdPath, dtbPath string, overlays []string, cmdline string, dtbOptional bool) error {
+	if len(overlays) > 0 {
+		if dtbPath == "" {
+			fmt.Fprintf(os.Stderr, "Warning: devicetree overlays ignored, entry has no devicetree\n")
+		} else {
+			mergedPath, err := applyOverlays(dtbPath, overlays)
+			if err != nil {
+				return fmt.Errorf("failed to apply devicetree overlays: %v", err)
+			}
+			defer os.Remove(mergedPath)
+			dtbPath = mergedPath
+		}
+	}
 
-	return cmd.Run()
+	fmt.Println("Loading linux...")
+	return loadWithBackend(kernelPath, initrdPath, dtbPath, cmdline, dtbOptional)
 }
@@ -0,0 +1,82 @@
+package kexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/timoxa0/kxmenu/fdt"
+)
+
+// OverlayDir is a directory of .dtbo overlays applied to every devicetree
+// after the entry's own overlays. Empty disables it.
+var OverlayDir string
+
+// overlayPaths returns the overlays to apply for an entry: its
+// devicetree-overlay files, then those in OverlayDir sorted by name
+func overlayPaths(overlays []string, bootRoot string) []string {
+	var paths []string
+	for _, overlay := range overlays {
+		paths = append(paths, filepath.Join(bootRoot, overlay))
+	}
+
+	if OverlayDir != "" {
+		matches, err := filepath.Glob(filepath.Join(OverlayDir, "*.dtbo"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to list overlays: %v\n", err)
+		}
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+
+	return paths
+}
+
+// applyOverlays merges overlays into the devicetree at dtbPath and writes the
+// result to a temporary file. An overlay that fails to apply is reported
+// and skipped, leaving the tree as it was before it.
+func applyOverlays(dtbPath string, overlays []string) (string, error) {
+	fmt.Println("Applying devicetree overlays...")
+
+	merged, err := os.ReadFile(dtbPath)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fdt.Parse(merged); err != nil {
+		return "", fmt.Errorf("%s: %v", dtbPath, err)
+	}
+
+	for _, overlayPath := range overlays {
+		result, err := applyOverlay(merged, overlayPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: overlay %s not applied: %v\n", overlayPath, err)
+			continue
+		}
+		merged = result
+	}
+
+	return writeTemp("kexec-merged-*.dtb", merged)
+}
+
+// applyOverlay applies one overlay file to a devicetree blob
+func applyOverlay(dtb []byte, overlayPath string) ([]byte, error) {
+	data, err := os.ReadFile(overlayPath)
+	if err != nil {
+		return nil, err
+	}
+	overlay, err := fdt.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Work on a fresh copy, a failed merge may leave the tree half done
+	base, err := fdt.Parse(dtb)
+	if err != nil {
+		return nil, err
+	}
+	if err := fdt.ApplyOverlay(base, overlay); err != nil {
+		return nil, err
+	}
+	return base.Marshal(), nil
+}
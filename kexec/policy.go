@@ -0,0 +1,57 @@
+package kexec
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/timoxa0/kxmenu/entry"
+)
+
+// CmdlinePolicy is the path of the command line policy file applied to
+// every entry before loading. A missing file applies no policy.
+var CmdlinePolicy = "/etc/kxmenu/cmdline.policy"
+
+// policyCache holds the policy read from CmdlinePolicy, read once per run
+// as every entry is prepared with it
+var policyCache struct {
+	sync.Mutex
+	path   string
+	read   bool
+	policy *entry.CmdlinePolicy
+}
+
+// cmdlinePolicy returns the policy of the CmdlinePolicy file, or nil if
+// there is none. A broken policy is reported once and applies nothing.
+func cmdlinePolicy() *entry.CmdlinePolicy {
+	policyCache.Lock()
+	defer policyCache.Unlock()
+
+	if policyCache.read && policyCache.path == CmdlinePolicy {
+		return policyCache.policy
+	}
+	policyCache.path, policyCache.read, policyCache.policy = CmdlinePolicy, true, nil
+
+	if CmdlinePolicy == "" {
+		return nil
+	}
+	if _, err := os.Stat(CmdlinePolicy); os.IsNotExist(err) {
+		return nil
+	}
+
+	policy, err := entry.ParseCmdlinePolicy(CmdlinePolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: command line policy not applied: %v\n", err)
+		return nil
+	}
+	policyCache.policy = policy
+	return policy
+}
+
+// applyCmdlinePolicy rewrites the entry's options with the policy file.
+// A broken policy leaves the options as they are.
+func applyCmdlinePolicy(bootEntry *entry.BootEntry) {
+	if policy := cmdlinePolicy(); policy != nil {
+		bootEntry.Options = policy.Apply(bootEntry)
+	}
+}
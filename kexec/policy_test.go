@@ -0,0 +1,81 @@
+package kexec
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/timoxa0/kxmenu/entry"
+)
+
+// usePolicy points CmdlinePolicy at a policy file holding text for the test
+func usePolicy(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cmdline.policy")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := CmdlinePolicy
+	CmdlinePolicy = path
+	t.Cleanup(func() { CmdlinePolicy = saved })
+	return path
+}
+
+// captureStderr returns what fn writes to stderr
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	fn()
+	os.Stderr = saved
+	w.Close()
+
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
+
+func TestCmdlinePolicyReadOnce(t *testing.T) {
+	path := usePolicy(t, "remove quiet\nappend console=ttyS0\n")
+
+	a := &entry.BootEntry{Options: "root=/dev/sda1 quiet"}
+	applyCmdlinePolicy(a)
+
+	// Later changes to the file do not apply within the run
+	if err := os.WriteFile(path, []byte("append debug\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := &entry.BootEntry{Options: "ro quiet"}
+	applyCmdlinePolicy(b)
+
+	if a.Options != "root=/dev/sda1 console=ttyS0" || b.Options != "ro console=ttyS0" {
+		t.Errorf("options = %q, %q", a.Options, b.Options)
+	}
+}
+
+func TestCmdlinePolicyBroken(t *testing.T) {
+	usePolicy(t, "append console=ttyS0\nfrobnicate quiet\n")
+
+	var entries []*entry.BootEntry
+	stderr := captureStderr(t, func() {
+		for range 3 {
+			e := &entry.BootEntry{Options: "ro quiet"}
+			applyCmdlinePolicy(e)
+			entries = append(entries, e)
+		}
+	})
+
+	if n := strings.Count(stderr, "Warning: command line policy not applied"); n != 1 {
+		t.Errorf("broken policy reported %d times, want once:\n%s", n, stderr)
+	}
+	for _, e := range entries {
+		if e.Options != "ro quiet" {
+			t.Errorf("broken policy changed options to %q", e.Options)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+package kexec
+
+// sysKexecFileLoad is the kexec_file_load system call number
+const sysKexecFileLoad = 320
@@ -0,0 +1,6 @@
+//go:build !amd64 && !arm64 && !riscv64 && !loong64
+
+package kexec
+
+// sysKexecFileLoad is negative where kexec_file_load is not available
+var sysKexecFileLoad = -1 // A variable, a negative constant cannot convert to uintptr
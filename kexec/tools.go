@@ -0,0 +1,52 @@
+package kexec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// toolsBackend loads kernels by running the kexec binary of kexec-tools
+type toolsBackend struct{}
+
+func (toolsBackend) Name() string {
+	return "tools"
+}
+
+func (toolsBackend) Load(kernelPath, initrdPath, dtbPath, cmdline string) error {
+	args := []string{"--load", kernelPath}
+
+	// Add initrd if specified
+	if initrdPath != "" {
+		args = append(args, "--initrd="+initrdPath)
+	}
+
+	// Add device tree if specified
+	if dtbPath != "" {
+		args = append(args, "--dtb="+dtbPath)
+	}
+
+	// Add command line options if specified
+	if cmdline != "" {
+		args = append(args, "--command-line="+cmdline)
+	}
+
+	if Debug {
+		fmt.Fprintf(os.Stderr, "kexec %s\n", strings.Join(args, " "))
+	}
+
+	cmd := exec.Command("kexec", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (toolsBackend) Execute() error {
+	cmd := exec.Command("kexec", "-e")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
@@ -0,0 +1,37 @@
+package kexec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Offsets in the EFI zboot header, which replaces the DOS header of the
+// PE stub wrapping a compressed Image
+const (
+	zbootMagic         = 0x04
+	zbootPayloadOffset = 0x08
+	zbootPayloadSize   = 0x0c
+	zbootCompType      = 0x18
+	zbootHeaderSize    = 0x38 // Compression type ends before the PE magic
+)
+
+// zbootPayload returns the compressed payload of an EFI zboot image and
+// the compression type named in its header. Other data has no payload.
+func zbootPayload(data []byte) ([]byte, string, error) {
+	if len(data) < zbootHeaderSize || string(data[:2]) != "MZ" || string(data[zbootMagic:zbootMagic+4]) != "zimg" {
+		return nil, "", nil
+	}
+
+	offset := uint64(binary.LittleEndian.Uint32(data[zbootPayloadOffset:]))
+	size := uint64(binary.LittleEndian.Uint32(data[zbootPayloadSize:]))
+	if offset+size > uint64(len(data)) {
+		return nil, "", fmt.Errorf("zboot payload at 0x%x+0x%x exceeds the image", offset, size)
+	}
+
+	compType := data[zbootCompType:zbootHeaderSize]
+	if end := bytes.IndexByte(compType, 0); end >= 0 {
+		compType = compType[:end]
+	}
+	return data[offset : offset+size], string(compType), nil
+}
@@ -0,0 +1,122 @@
+package kexec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// zbootImage wraps a payload in a minimal EFI zboot header
+func zbootImage(payload []byte, compType string) []byte {
+	image := make([]byte, 0x40, 0x40+len(payload))
+	copy(image, "MZ")
+	copy(image[zbootMagic:], "zimg")
+	binary.LittleEndian.PutUint32(image[zbootPayloadOffset:], 0x40)
+	binary.LittleEndian.PutUint32(image[zbootPayloadSize:], uint32(len(payload)))
+	copy(image[zbootCompType:zbootHeaderSize], compType)
+	return append(image, payload...)
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestZbootPayload(t *testing.T) {
+	payload := []byte("compressed payload")
+	image := zbootImage(payload, "gzip")
+
+	got, compType, err := zbootPayload(image)
+	if err != nil || !bytes.Equal(got, payload) || compType != "gzip" {
+		t.Errorf("zbootPayload() = %q, %q, %v, want %q, gzip", got, compType, err, payload)
+	}
+
+	// Other images have no payload
+	for _, data := range [][]byte{readTestdata(t, "Image"), image[:zbootHeaderSize-1], nil} {
+		if got, _, err := zbootPayload(data); got != nil || err != nil {
+			t.Errorf("zbootPayload() of %d bytes = %d bytes, %v, want none", len(data), len(got), err)
+		}
+	}
+
+	// The payload must lie within the image
+	binary.LittleEndian.PutUint32(image[zbootPayloadSize:], uint32(len(payload)+1))
+	if _, _, err := zbootPayload(image); err == nil {
+		t.Errorf("zbootPayload() accepted a payload beyond the image")
+	}
+	binary.LittleEndian.PutUint32(image[zbootPayloadOffset:], 0xffffffff)
+	binary.LittleEndian.PutUint32(image[zbootPayloadSize:], 0xffffffff)
+	if _, _, err := zbootPayload(image); err == nil {
+		t.Errorf("zbootPayload() accepted an offset beyond the image")
+	}
+}
+
+func TestDecompressZbootKernel(t *testing.T) {
+	kernel := readTestdata(t, "Image")
+
+	tests := []struct {
+		name  string
+		image []byte
+		err   string
+	}{
+		{"gzip", zbootImage(gzipData(t, kernel), "gzip"), ""},
+		{"zstd", zbootImage(readTestdata(t, "Image.zst"), "zstd22"), ""},
+		{"out of range", zbootImage(gzipData(t, kernel), "gzip")[:0x50], "exceeds the image"},
+		{"unsupported", zbootImage([]byte("lzo compressed data"), "lzo"), `unsupported zboot compression "lzo"`},
+		{"not a kernel", zbootImage(gzipData(t, []byte("not a kernel")), "gzip"), "not a kernel image"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "vmlinuz")
+			if err := os.WriteFile(path, tt.image, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := decompressKernel(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("decompressKernel() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decompressKernel() error: %v", err)
+			}
+			defer os.Remove(out)
+
+			got, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, kernel) {
+				t.Errorf("decompressKernel() unpacked %d bytes differing from the Image", len(got))
+			}
+		})
+	}
+}
+
+func TestDecompressPlainKernel(t *testing.T) {
+	path := filepath.Join("testdata", "Image")
+	if out, err := decompressKernel(path); out != path || err != nil {
+		t.Errorf("decompressKernel() = %q, %v, want the Image itself", out, err)
+	}
+}
@@ -0,0 +1,165 @@
+package menu
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/timoxa0/kxmenu/entry"
+	"github.com/timoxa0/kxmenu/input"
+)
+
+// lineEditor is a single line of text with a cursor
+type lineEditor struct {
+	label  string
+	text   []rune
+	cursor int
+}
+
+// newLineEditor creates an editor with the cursor at the end of text
+func newLineEditor(label, text string) *lineEditor {
+	runes := []rune(text)
+	return &lineEditor{label: label, text: runes, cursor: len(runes)}
+}
+
+// handle applies an editing key and reports whether it was one
+func (l *lineEditor) handle(event input.KeyEvent) bool {
+	switch event.Code {
+	case input.KeyLeft:
+		if l.cursor > 0 {
+			l.cursor--
+		}
+	case input.KeyRight:
+		if l.cursor < len(l.text) {
+			l.cursor++
+		}
+	case input.KeyHome:
+		l.cursor = 0
+	case input.KeyEnd:
+		l.cursor = len(l.text)
+	case input.KeyBackspace:
+		if l.cursor > 0 {
+			l.text = append(l.text[:l.cursor-1], l.text[l.cursor:]...)
+			l.cursor--
+		}
+	case input.KeyDelete:
+		if l.cursor < len(l.text) {
+			l.text = append(l.text[:l.cursor], l.text[l.cursor+1:]...)
+		}
+	default:
+		// Number keys and q also type characters while editing
+		if event.Rune == 0 {
+			return false
+		}
+		l.text = append(l.text[:l.cursor], append([]rune{event.Rune}, l.text[l.cursor:]...)...)
+		l.cursor++
+	}
+	return true
+}
+
+// String returns the edited text
+func (l *lineEditor) String() string {
+	return string(l.text)
+}
+
+// draw prints the label and the text with the cursor shown in reverse
+// video if active, wrapped to width
+func (l *lineEditor) draw(width int, active bool, theme Theme) {
+	label := BoldText + l.label + ":" + ResetColor
+	if active {
+		label = BoldText + theme.Group + l.label + ":" + ResetColor
+	}
+	fmt.Printf(" %s\n", label)
+
+	var b strings.Builder
+	column := 0
+	for i := 0; i <= len(l.text); i++ {
+		if column == 0 {
+			b.WriteString("   ")
+		}
+
+		char := " "
+		if i < len(l.text) {
+			char = string(l.text[i])
+		}
+		if active && i == l.cursor {
+			b.WriteString(ReverseVideo + char + ResetColor)
+		} else {
+			b.WriteString(char)
+		}
+
+		if column++; column >= max(1, width-4) {
+			b.WriteString("\n")
+			column = 0
+		}
+	}
+	fmt.Printf("%s\n\n", strings.TrimRight(b.String(), "\n"))
+}
+
+// editEntry lets the user edit the kernel command line, initrds and
+// devicetree of an entry before booting it. It returns an edited copy,
+// leaving the entry and its files untouched, or nil if editing was
+// cancelled.
+func (m *BootMenu) editEntry(e *entry.BootEntry, events <-chan input.KeyEvent) *entry.BootEntry {
+	fields := []*lineEditor{newLineEditor("Options", e.Options)}
+	if e.Linux != "" {
+		// Initrds and devicetree only apply to plain kernels
+		fields = append(fields,
+			newLineEditor("Initrd", strings.Join(e.Initrd, " ")),
+			newLineEditor("Devicetree", e.Devicetree))
+	}
+	active := 0
+
+	for {
+		m.drawEditor(e, fields, active)
+
+		event := <-events
+		switch event.Code {
+		case input.KeySelect:
+			edited := *e
+			edited.Options = strings.Join(entry.SplitCmdline(fields[0].String()), " ")
+			if len(fields) > 1 {
+				edited.Initrd = strings.Fields(fields[1].String())
+				edited.Devicetree = strings.TrimSpace(fields[2].String())
+				if edited.Devicetree != e.Devicetree {
+					edited.DevicetreeReason = ""
+				}
+			}
+			return &edited
+
+		case input.KeyEscape:
+			return nil
+
+		case input.KeyUp:
+			if active > 0 {
+				active--
+			}
+
+		case input.KeyDown:
+			if active < len(fields)-1 {
+				active++
+			}
+
+		default:
+			fields[active].handle(event)
+		}
+	}
+}
+
+// drawEditor renders the entry editor
+func (m *BootMenu) drawEditor(e *entry.BootEntry, fields []*lineEditor, active int) {
+	fmt.Print(ClearScreen + EscSeq + "1;1H")
+
+	title := "Editing " + e.Title
+	titlePadding := max(0, (m.Terminal.Width-len(title))/2)
+	fmt.Print(strings.Repeat(" ", titlePadding) + m.Theme.Title + title + ResetColor + "\n\n")
+
+	for i, field := range fields {
+		field.draw(m.Terminal.Width, i == active, m.Theme)
+	}
+
+	// Controls footer (centered)
+	fmt.Print(EscSeq + fmt.Sprintf("%d;1H", m.Terminal.Height))
+	footer := "Enter to boot, Esc to discard changes, Up/Down to switch fields"
+	footerPadding := max(0, (m.Terminal.Width-len(footer))/2)
+	fmt.Print(strings.Repeat(" ", footerPadding) + m.Theme.Footer + footer + ResetColor)
+}
@@ -26,6 +26,7 @@ type MenuItem struct {
 	Entry       *entry.BootEntry
 	DisplayName string
 	Description string
+	Group       string // Heading shown above the first item of each group
 }
 
 // BootMenu represents the interactive boot menu
@@ -34,10 +35,17 @@ type BootMenu struct {
 	SelectedIndex int
 	Terminal      *Terminal
 	Title         string
+	Footer        string // Help text at the bottom, empty for the default
+	Theme         Theme
 	Timeout       int                 // seconds, 0 = no timeout
+	Hidden        bool                // boot the selected entry without showing the menu unless a key is pressed
+	Editor        bool                // allow editing the kernel command line
 	InputManager  *input.InputManager // Hardware input support
 }
 
+// badMarker follows the names of entries that failed to boot
+const badMarker = " [bad]"
+
 // ANSI escape codes for terminal control
 const (
 	EscSeq        = "\033["
@@ -119,14 +127,24 @@ func getTerminalSize() (int, int) {
 	return int(ws.Col), int(ws.Row)
 }
 
-// NewBootMenu creates a new boot menu
+// NewBootMenu creates a new boot menu, skipping entries built for another architecture
 func NewBootMenu(entries []*entry.BootEntry, title string) *BootMenu {
+	var bootable []*entry.BootEntry
+	for _, e := range entries {
+		if e.MatchesHost() {
+			bootable = append(bootable, e)
+		}
+	}
+	entries = bootable
+
 	menu := &BootMenu{
 		Items:         make([]MenuItem, len(entries)),
 		SelectedIndex: 0,
 		Terminal:      NewTerminal(),
 		Title:         title,
+		Theme:         DefaultTheme,
 		Timeout:       0,
+		Editor:        true,
 	}
 
 	// Convert entries to menu items
@@ -146,6 +164,18 @@ func NewBootMenu(entries []*entry.BootEntry, title string) *BootMenu {
 			}
 			description += fmt.Sprintf("Kernel: %s", e.Linux)
 		}
+		if e.Linux == "" && e.Efi != "" {
+			if description != "" {
+				description += " | "
+			}
+			description += fmt.Sprintf("UKI: %s", e.Efi)
+		}
+		if e.AndroidBoot != "" {
+			if description != "" {
+				description += " | "
+			}
+			description += fmt.Sprintf("Boot image: %s", e.AndroidBoot)
+		}
 		if e.Devicetree != "" {
 			if description != "" {
 				description += " | "
@@ -175,8 +205,33 @@ func (m *BootMenu) SetTimeout(seconds int) {
 	m.Timeout = seconds
 }
 
+// SelectEntry preselects the entry with the given ID and reports whether it was found
+func (m *BootMenu) SelectEntry(id string) bool {
+	for i, item := range m.Items {
+		if item.Entry.ID == id {
+			m.SelectedIndex = i
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenGracePeriod is how long a hidden menu waits for a key that shows
+// it, as systemd-boot does, so a broken default entry cannot lock the
+// machine out
+const hiddenGracePeriod = 1500 * time.Millisecond
+
 // Show displays the boot menu and handles user interaction
 func (m *BootMenu) Show() (*entry.BootEntry, error) {
+	if m.Hidden {
+		if !m.keyPressedWithin(hiddenGracePeriod) {
+			return m.Items[m.SelectedIndex].Entry, nil
+		}
+		// Whoever pressed the key chooses, without a timeout
+		m.Hidden = false
+		m.Timeout = 0
+	}
+
 	if !m.Terminal.IsTTY {
 		// Fallback to simple text menu for non-TTY
 		return m.showSimpleMenu()
@@ -192,25 +247,41 @@ func (m *BootMenu) Show() (*entry.BootEntry, error) {
 	return m.showInteractiveMenu()
 }
 
+// keyPressedWithin polls the input manager for a key press until the
+// period has passed
+func (m *BootMenu) keyPressedWithin(period time.Duration) bool {
+	if m.InputManager == nil {
+		return false
+	}
+	deadline := time.Now().Add(period)
+	for time.Now().Before(deadline) {
+		if _, ok := m.InputManager.GetEventNonBlocking(); ok {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
 // showSimpleMenu shows a simple numbered list for non-TTY environments
 func (m *BootMenu) showSimpleMenu() (*entry.BootEntry, error) {
 	fmt.Printf("\n%s\n", m.Title)
 	fmt.Println(strings.Repeat("=", len(m.Title)))
 
 	for i, item := range m.Items {
-		fmt.Printf("%d. %s\n", i+1, item.DisplayName)
+		fmt.Printf("%d. %s\n", i+1, item.label())
 		if item.Description != "" {
 			fmt.Printf("   %s\n", item.Description)
 		}
 	}
 
-	fmt.Printf("\nSelect entry (1-%d) [default: 1]: ", len(m.Items))
+	fmt.Printf("\nSelect entry (1-%d) [default: %d]: ", len(m.Items), m.SelectedIndex+1)
 
 	var input string
 	fmt.Scanln(&input)
 
 	if input == "" {
-		return m.Items[0].Entry, nil
+		return m.Items[m.SelectedIndex].Entry, nil
 	}
 
 	selection, err := strconv.Atoi(input)
@@ -246,36 +317,24 @@ func (m *BootMenu) restoreTerminal() {
 
 // showInteractiveMenu shows the interactive GRUB2-style menu with hardware input support
 func (m *BootMenu) showInteractiveMenu() (*entry.BootEntry, error) {
-	// Channel for timeout handling
-	timeoutCh := make(chan bool, 1)
-	inputCh := make(chan byte, 1)
-
-	// Start timeout if configured
-	if m.Timeout > 0 {
-		go func() {
-			time.Sleep(time.Duration(m.Timeout) * time.Second)
-			timeoutCh <- true
-		}()
-	}
+	events := make(chan input.KeyEvent, 1)
 
 	// Start hardware input reader
 	if m.InputManager == nil {
 		return nil, fmt.Errorf("no input manager available - hardware input required")
 	}
 
+	// Start timeout if configured. A timer that never fires stands in for
+	// no timeout, so the loop always has a channel to wait on.
+	timer := time.NewTimer(time.Duration(m.Timeout) * time.Second)
+	if m.Timeout <= 0 {
+		timer.Stop()
+	}
+	defer timer.Stop()
+
 	go func() {
 		for {
-			event := m.InputManager.GetEvent()
-			switch event.Code {
-			case input.KeyUp:
-				inputCh <- 'k' // Simulate Vi up key
-			case input.KeyDown:
-				inputCh <- 'j' // Simulate Vi down key
-			case input.KeySelect:
-				inputCh <- 13 // Simulate Enter
-			case input.KeyQuit, input.KeyEscape:
-				inputCh <- 'q' // Simulate quit
-			}
+			events <- m.InputManager.GetEvent()
 		}
 	}()
 
@@ -284,27 +343,36 @@ func (m *BootMenu) showInteractiveMenu() (*entry.BootEntry, error) {
 		m.drawMenu()
 
 		select {
-		case <-timeoutCh:
+		case <-timer.C:
 			// Timeout reached, select current item
 			return m.Items[m.SelectedIndex].Entry, nil
 
-		case key := <-inputCh:
-			switch key {
-			case 13: // Enter (from hardware input)
+		case event := <-events:
+			switch {
+			case event.Code == input.KeySelect:
 				return m.Items[m.SelectedIndex].Entry, nil
 
-			case 'q': // Quit (from hardware input)
+			case event.Code == input.KeyQuit || event.Code == input.KeyEscape:
 				return nil, fmt.Errorf("menu cancelled by user")
 
-			case 'j': // Vi-style down (from hardware input)
+			case event.Code == input.KeyDown:
 				if m.SelectedIndex < len(m.Items)-1 {
 					m.SelectedIndex++
 				}
 
-			case 'k': // Vi-style up (from hardware input)
+			case event.Code == input.KeyUp:
 				if m.SelectedIndex > 0 {
 					m.SelectedIndex--
 				}
+
+			case event.Rune == 'e' && m.Editor:
+				// The timeout must not boot while the user is editing
+				timer.Stop()
+				m.Timeout = 0
+
+				if edited := m.editEntry(m.Items[m.SelectedIndex].Entry, events); edited != nil {
+					return edited, nil
+				}
 			}
 		}
 	}
@@ -313,7 +381,7 @@ func (m *BootMenu) showInteractiveMenu() (*entry.BootEntry, error) {
 // drawMenu renders the boot menu
 func (m *BootMenu) drawMenu() {
 	// Calculate menu dimensions
-	menuItemsHeight := len(m.Items)
+	menuItemsHeight := len(m.Items) + m.groupCount()
 	titleHeight := 3      // title + separator + blank line
 	bottomInfoHeight := 6 // info panel + controls
 	totalMenuHeight := titleHeight + menuItemsHeight + bottomInfoHeight
@@ -326,13 +394,13 @@ func (m *BootMenu) drawMenu() {
 
 	// Draw title (centered)
 	titlePadding := max(0, (m.Terminal.Width-len(m.Title))/2)
-	fmt.Print(strings.Repeat(" ", titlePadding) + BoldText + CyanText + m.Title + ResetColor + "\n\n")
+	fmt.Print(strings.Repeat(" ", titlePadding) + m.Theme.Title + m.Title + ResetColor + "\n\n")
 
 	// Calculate menu item centering
 	maxItemWidth := 0
 	for _, item := range m.Items {
-		if len(item.DisplayName) > maxItemWidth {
-			maxItemWidth = len(item.DisplayName)
+		if len(item.label()) > maxItemWidth {
+			maxItemWidth = len(item.label())
 		}
 	}
 	maxItemWidth += 2 // Add padding
@@ -340,16 +408,22 @@ func (m *BootMenu) drawMenu() {
 
 	// Draw menu items (centered)
 	for i, item := range m.Items {
+		// Draw group heading when the group changes
+		if item.Group != "" && (i == 0 || m.Items[i-1].Group != item.Group) {
+			groupPadding := max(0, itemPadding-2)
+			fmt.Print(strings.Repeat(" ", groupPadding) + m.Theme.Group + item.Group + ResetColor + "\n")
+		}
+
 		prefix := ""
 		suffix := ""
 
 		if i == m.SelectedIndex {
-			prefix = BoldText + ReverseVideo
+			prefix = m.Theme.Highlight
 			suffix = ResetColor
 		}
 
 		// Truncate long names to fit terminal width
-		displayName := item.DisplayName
+		displayName := item.label()
 		maxNameWidth := m.Terminal.Width - itemPadding - 2
 		if len(displayName) > maxNameWidth {
 			displayName = displayName[:maxNameWidth-3] + "..."
@@ -383,23 +457,57 @@ func (m *BootMenu) drawMenu() {
 		// Kernel info
 		if selectedEntry.Linux != "" {
 			fmt.Printf(" %sKernel:%s %s\n", BoldText, ResetColor, selectedEntry.Linux)
+		} else if selectedEntry.Efi != "" {
+			fmt.Printf(" %sUKI:%s %s\n", BoldText, ResetColor, selectedEntry.Efi)
+		} else if selectedEntry.AndroidBoot != "" {
+			fmt.Printf(" %sBoot image:%s %s\n", BoldText, ResetColor, selectedEntry.AndroidBoot)
 		}
 
 		// Devicetree info
-		if selectedEntry.Devicetree != "" {
+		if selectedEntry.Devicetree != "" && selectedEntry.DevicetreeReason != "" {
+			fmt.Printf(" %sDevicetree:%s %s, matched by %s\n", BoldText, ResetColor, selectedEntry.Devicetree, selectedEntry.DevicetreeReason)
+		} else if selectedEntry.Devicetree != "" {
 			fmt.Printf(" %sDevicetree:%s %s\n", BoldText, ResetColor, selectedEntry.Devicetree)
+		} else if selectedEntry.DevicetreeDir != "" {
+			fmt.Printf(" %sDevicetree:%s no match for this board in %s\n", BoldText, ResetColor, selectedEntry.DevicetreeDir)
 		}
 	}
 
 	// Draw controls footer (centered)
 	fmt.Print(EscSeq + fmt.Sprintf("%d;1H", m.Terminal.Height))
-	footer := "Use Arrows/Volume Keys to select, Enter/Power to confirm"
+	footer := m.Footer
+	if footer == "" {
+		footer = "Use Arrows/Volume Keys to select, Enter/Power to confirm"
+		if m.Editor {
+			footer += ", e to edit"
+		}
+	}
 	if m.Timeout > 0 {
 		footer += fmt.Sprintf(" (timeout: %ds)", m.Timeout)
 	}
 
 	footerPadding := max(0, (m.Terminal.Width-len(footer))/2)
-	fmt.Print(strings.Repeat(" ", footerPadding) + WhiteText + footer + ResetColor)
+	fmt.Print(strings.Repeat(" ", footerPadding) + m.Theme.Footer + footer + ResetColor)
+}
+
+// label returns the text shown for an item in the menu list. Entries that
+// ran out of boot attempts are marked.
+func (item MenuItem) label() string {
+	if item.Entry.IsBad() {
+		return item.DisplayName + badMarker
+	}
+	return item.DisplayName
+}
+
+// groupCount returns the number of group headings drawn above the items
+func (m *BootMenu) groupCount() int {
+	count := 0
+	for i, item := range m.Items {
+		if item.Group != "" && (i == 0 || m.Items[i-1].Group != item.Group) {
+			count++
+		}
+	}
+	return count
 }
 
 // Helper functions
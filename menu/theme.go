@@ -0,0 +1,86 @@
+package menu
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Theme holds the escape sequences used to draw the parts of the menu
+type Theme struct {
+	Title     string
+	Highlight string // Selected entry
+	Group     string // Group headings and active editor fields
+	Footer    string
+}
+
+// themes are the built-in themes selectable by name
+var themes = map[string]Theme{
+	"default": {
+		Title:     BoldText + CyanText,
+		Highlight: BoldText + ReverseVideo,
+		Group:     CyanText,
+		Footer:    WhiteText,
+	},
+	"mono": {
+		Title:     BoldText,
+		Highlight: ReverseVideo,
+		Group:     BoldText,
+		Footer:    "",
+	},
+}
+
+// DefaultTheme is the theme used unless configured otherwise
+var DefaultTheme = themes["default"]
+
+// colorCodes maps color and attribute names to SGR parameters
+var colorCodes = map[string]string{
+	"bold":      "1",
+	"dim":       "2",
+	"underline": "4",
+	"reverse":   "7",
+	"black":     "30",
+	"red":       "31",
+	"green":     "32",
+	"yellow":    "33",
+	"blue":      "34",
+	"magenta":   "35",
+	"cyan":      "36",
+	"white":     "37",
+}
+
+// LookupTheme returns the built-in theme with the given name
+func LookupTheme(name string) (Theme, error) {
+	theme, ok := themes[name]
+	if !ok {
+		return Theme{}, fmt.Errorf("unknown theme %q (known: %s)", name, strings.Join(ThemeNames(), ", "))
+	}
+	return theme, nil
+}
+
+// ThemeNames returns the names of the built-in themes
+func ThemeNames() []string {
+	var names []string
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseColor converts a space-separated list of color and attribute names,
+// e.g. "bold cyan", to an escape sequence. "none" gives plain text.
+func ParseColor(spec string) (string, error) {
+	var seq strings.Builder
+	for _, word := range strings.Fields(spec) {
+		if word == "none" {
+			continue
+		}
+		code, ok := colorCodes[word]
+		if !ok {
+			return "", fmt.Errorf("unknown color %q", word)
+		}
+		seq.WriteString(EscSeq + code + "m")
+	}
+	return seq.String(), nil
+}
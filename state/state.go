@@ -0,0 +1,155 @@
+package state
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Keys used by kxmenu
+const (
+	// LastEntry is the ID of the entry booted last
+	LastEntry = "last_entry"
+
+	// OneshotEntry is the ID of an entry to boot once on the next run
+	OneshotEntry = "oneshot_entry"
+)
+
+// ErrReadOnly is returned by Save when the state file cannot be written
+// because its filesystem is read-only or not writable by us
+var ErrReadOnly = errors.New("state storage is read-only")
+
+// Store is a small key=value store persisted in a single file
+type Store struct {
+	path    string
+	values  map[string]string
+	changed bool
+}
+
+// Open reads the state file at path. A missing or unreadable file gives an
+// empty store, so booting never depends on the state being available. An
+// empty path gives a store that is never saved.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, values: map[string]string{}}
+	if path == "" {
+		return s, nil
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		s.values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return s, scanner.Err()
+}
+
+// Path returns the location of the state file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Get returns the value of key, empty if unset
+func (s *Store) Get(key string) string {
+	return s.values[key]
+}
+
+// Set changes the value of key. An empty value removes the key.
+func (s *Store) Set(key, value string) {
+	// Values are stored one per line
+	value = strings.ReplaceAll(value, "\n", " ")
+
+	if s.values[key] == value {
+		return
+	}
+	if value == "" {
+		delete(s.values, key)
+	} else {
+		s.values[key] = value
+	}
+	s.changed = true
+}
+
+// Save writes the store back if it changed. The file is replaced
+// atomically so a power loss leaves either the old or the new state.
+func (s *Store) Save() error {
+	if !s.changed || s.path == "" {
+		return nil // Spare flash media needless writes
+	}
+
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("# kxmenu boot state\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", key, s.values[key])
+	}
+
+	if err := writeAtomic(s.path, []byte(b.String())); err != nil {
+		if isReadOnly(err) {
+			return fmt.Errorf("%s: %w", s.path, ErrReadOnly)
+		}
+		return fmt.Errorf("failed to save state to %s: %v", s.path, err)
+	}
+
+	s.changed = false
+	return nil
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it
+// over path
+func writeAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), ".kxmenu-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// isReadOnly checks if err means the storage cannot be written at all
+func isReadOnly(err error) bool {
+	return errors.Is(err, syscall.EROFS) || errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM)
+}